@@ -0,0 +1,56 @@
+/*
+ * Copyright (c) 2023 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package ringbuffer
+
+import (
+	"io"
+	"os"
+	"unsafe"
+)
+
+// alignedBuffer returns a slice of exactly size bytes, rounded up to a
+// multiple of the page size, whose first byte sits on a page boundary.
+// It over-allocates by a page and slices into it, which relies on the Go
+// heap not moving objects; that holds for the gc toolchain on all
+// platforms but is not guaranteed by the language.
+func alignedBuffer(size int) []byte {
+	pagesize := os.Getpagesize()
+	if rem := size % pagesize; rem != 0 || size == 0 {
+		size += pagesize - rem
+	}
+
+	buf := make([]byte, size+pagesize)
+	off := 0
+	if rem := int(uintptr(unsafe.Pointer(&buf[0])) & uintptr(pagesize-1)); rem != 0 {
+		off = pagesize - rem
+	}
+	return buf[off : off+size : off+size]
+}
+
+// WithPageAlignment allocates the backing array on a page boundary, with
+// its size rounded up to a multiple of the page size, as required by e.g.
+// O_DIRECT reads on Linux. It reallocates the buffer, so it must come after
+// options that change its size such as WithHistory.
+func WithPageAlignment() Option {
+	return func(rb *RingBuffer) {
+		rb.buffer = alignedBuffer(cap(rb.buffer))
+	}
+}
+
+func NewAligned(rd io.Reader, size int) *RingBuffer {
+	return New(size, WithReader(rd), WithPageAlignment())
+}
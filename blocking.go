@@ -0,0 +1,240 @@
+/*
+ * Copyright (c) 2023 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package ringbuffer
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+func (rb *RingBuffer) signalNotEmpty() {
+	if rb.notEmpty != nil {
+		rb.notEmpty.Broadcast()
+	}
+}
+
+func (rb *RingBuffer) signalNotFull() {
+	if rb.notFull != nil {
+		rb.notFull.Broadcast()
+	}
+}
+
+// wait blocks on cond until ready returns true or ctx is done. It must be
+// called with rb.mu held.
+func (rb *RingBuffer) wait(ctx context.Context, cond *sync.Cond, ready func() bool) error {
+	if ready() {
+		return nil
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			rb.mu.Lock()
+			cond.Broadcast()
+			rb.mu.Unlock()
+		case <-done:
+		}
+	}()
+
+	for !ready() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		cond.Wait()
+	}
+	return nil
+}
+
+// ReadContext is like Read but, on a buffer created with WithLocking, blocks
+// until at least one byte has been written or ctx is done. Buffers without
+// a mutex have no concurrent writer to wait for and behave like Read.
+func (rb *RingBuffer) ReadContext(ctx context.Context, p []byte) (int, error) {
+	rb.lock()
+	defer rb.unlock()
+
+	if !rb.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, rb.deadline)
+		defer cancel()
+	}
+	n, err := rb.readContext(ctx, p)
+	if err == context.DeadlineExceeded && rb.deadlinePassed() {
+		err = os.ErrDeadlineExceeded
+	}
+	return n, err
+}
+
+func (rb *RingBuffer) readContext(ctx context.Context, p []byte) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if rb.notEmpty != nil && len(p) != 0 {
+		err := rb.wait(ctx, rb.notEmpty, func() bool {
+			return rb.unlockedLen() != 0 || rb.rd != nil || rb.rdErr != nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	rb.ctx = ctx
+	defer func() { rb.ctx = nil }()
+
+	n, err := rb.unlockedRead(p)
+	if n == 0 && err == nil {
+		err = ctx.Err()
+	}
+	return n, err
+}
+
+// SetReadDeadline makes Read and ReadContext calls fail with
+// os.ErrDeadlineExceeded once t has passed, as they do on a net.Conn. A
+// zero t means no deadline. It applies to calls made after it returns.
+func (rb *RingBuffer) SetReadDeadline(t time.Time) error {
+	rb.lock()
+	defer rb.unlock()
+	rb.deadline = t
+	return nil
+}
+
+func (rb *RingBuffer) deadlinePassed() bool {
+	return !rb.deadline.IsZero() && !time.Now().Before(rb.deadline)
+}
+
+// WaitForBytes blocks until at least n bytes are buffered, refilling from
+// the reader or, on a buffer created with WithLocking and no reader,
+// waiting for writes. It fails with io.EOF if the source ends first.
+func (rb *RingBuffer) WaitForBytes(ctx context.Context, n int) error {
+	rb.lock()
+	defer rb.unlock()
+
+	if n > cap(rb.buffer)-rb.retained {
+		return io.ErrShortBuffer
+	}
+
+	rb.ctx = ctx
+	defer func() { rb.ctx = nil }()
+
+	for rb.unlockedLen() < n {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		switch {
+		case rb.refillable():
+			rb.prefillBuffer()
+		case rb.rdErr != nil || rb.pending != nil || rb.notEmpty == nil:
+			return rb.drainedErr()
+		default:
+			err := rb.wait(ctx, rb.notEmpty, func() bool {
+				return rb.unlockedLen() >= n || rb.rd != nil || rb.rdErr != nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WriteContext is like Write but, on a buffer created with WithLocking,
+// blocks whenever the buffer is full until reads make room for all of p,
+// ctx is done or the buffer is closed. Buffers without a mutex have no
+// concurrent reader to wait for and behave like Write.
+func (rb *RingBuffer) WriteContext(ctx context.Context, p []byte) (int, error) {
+	rb.lock()
+	defer rb.unlock()
+
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if rb.notFull == nil || rb.overwrite {
+		return write(rb, p)
+	}
+
+	total := 0
+	for len(p) != 0 {
+		err := rb.wait(ctx, rb.notFull, func() bool {
+			return rb.unlockedCapacity() != 0 || rb.rdErr == ErrClosed
+		})
+		if err != nil {
+			return total, err
+		}
+		if rb.rdErr == ErrClosed {
+			return total, ErrClosed
+		}
+
+		n, _ := write(rb, p)
+		total += n
+		p = p[n:]
+	}
+	return total, nil
+}
+
+// PeekContext is like PeekFull but gives up once ctx is done, returning
+// ctx.Err() along with whatever is buffered. The context is checked
+// between reads from the reader, and while waiting for writes on a buffer
+// created with WithLocking and no reader.
+func (rb *RingBuffer) PeekContext(ctx context.Context, n int) ([]byte, error) {
+	rb.lock()
+	defer rb.unlock()
+
+	if n < 0 {
+		return nil, ErrNegativeCount
+	}
+	if n > cap(rb.buffer)-rb.retained {
+		return nil, ErrBufferFull
+	}
+
+	rb.ctx = ctx
+	defer func() { rb.ctx = nil }()
+
+	var err error
+loop:
+	for rb.unlockedLen() < n {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+
+		switch {
+		case rb.refillable():
+			rb.prefillBuffer()
+		case rb.rdErr != nil || rb.pending != nil || rb.notEmpty == nil:
+			err = rb.shortErr(rb.unlockedLen())
+			break loop
+		default:
+			err = rb.wait(ctx, rb.notEmpty, func() bool {
+				return rb.unlockedLen() >= n || rb.rd != nil || rb.rdErr != nil
+			})
+			if err != nil {
+				break loop
+			}
+		}
+	}
+
+	rblen := rb.unlockedLen()
+	if rblen > n {
+		rblen = n
+	}
+	rb.lastByte = false
+	return rb.contiguous(rblen), err
+}
@@ -0,0 +1,94 @@
+/*
+ * Copyright (c) 2023 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package ringbuffer
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+var ErrInvalidConsumer = errors.New("ringbuffer: invalid consumer id")
+
+// Broadcaster delivers the whole stream read from a reader to several
+// consumers sharing one backing buffer. Bytes are only released once every
+// consumer has read them, so a consumer that caught up with a full buffer
+// blocks until the slowest one makes progress: each consumer is expected
+// to run in its own goroutine.
+type Broadcaster struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	rb      *RingBuffer
+	base    int64 // stream offset of rb.head
+	cursors []int64
+}
+
+func NewBroadcaster(rd io.Reader, size int, nConsumers int) *Broadcaster {
+	b := &Broadcaster{
+		rb:      NewReaderSize(rd, size),
+		cursors: make([]int64, nConsumers),
+	}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+func (b *Broadcaster) ReadFor(id int, p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if id < 0 || id >= len(b.cursors) {
+		return 0, ErrInvalidConsumer
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	rb := b.rb
+	for b.base+int64(rb.unlockedLen()) == b.cursors[id] {
+		switch {
+		case !rb.refillable():
+			return 0, rb.drainedErr()
+		case rb.unlockedCapacity() != 0:
+			rb.prefillBuffer()
+			b.cond.Broadcast()
+		default:
+			b.cond.Wait()
+		}
+	}
+
+	off := int(b.cursors[id] - b.base)
+	n := rb.unlockedLen() - off
+	if n > len(p) {
+		n = len(p)
+	}
+	rb.copyToBuffer(p[:n], (rb.head+off)%cap(rb.buffer))
+	b.cursors[id] += int64(n)
+
+	slowest := b.cursors[0]
+	for _, cursor := range b.cursors[1:] {
+		if cursor < slowest {
+			slowest = cursor
+		}
+	}
+	if slowest > b.base {
+		rb.unlockedDiscard(int(slowest - b.base))
+		b.base = slowest
+		b.cond.Broadcast()
+	}
+	return n, nil
+}
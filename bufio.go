@@ -0,0 +1,139 @@
+/*
+ * Copyright (c) 2023 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package ringbuffer
+
+import (
+	"bufio"
+	"io"
+)
+
+// BufioReader exposes a RingBuffer with the method set of bufio.Reader,
+// including its error values, so that it can stand in for one.
+type BufioReader struct {
+	*RingBuffer
+}
+
+// NewBufioReaderSize is the counterpart of bufio.NewReaderSize.
+func NewBufioReaderSize(rd io.Reader, size int) *BufioReader {
+	return NewReaderSize(rd, size).BufioCompat()
+}
+
+func (rb *RingBuffer) BufioCompat() *BufioReader {
+	return &BufioReader{rb}
+}
+
+func bufioErr(err error) error {
+	switch err {
+	case ErrBufferFull:
+		return bufio.ErrBufferFull
+	case ErrNegativeCount:
+		return bufio.ErrNegativeCount
+	case ErrInvalidUnreadByte:
+		return bufio.ErrInvalidUnreadByte
+	case ErrInvalidUnreadRune:
+		return bufio.ErrInvalidUnreadRune
+	}
+	return err
+}
+
+// Peek returns the next n bytes without consuming them, with
+// bufio.ErrBufferFull if n is larger than the buffer.
+func (br *BufioReader) Peek(n int) ([]byte, error) {
+	p, err := br.RingBuffer.PeekStrict(n)
+	return p, bufioErr(err)
+}
+
+// Discard skips the next n bytes, refilling as needed, and returns the
+// reason if it skips fewer.
+func (br *BufioReader) Discard(n int) (int, error) {
+	rb := br.RingBuffer
+	rb.lock()
+	defer rb.unlock()
+
+	if n < 0 {
+		return 0, bufio.ErrNegativeCount
+	}
+
+	discarded := 0
+	for discarded < n {
+		if rb.unlockedLen() == 0 {
+			if !rb.refillable() {
+				return discarded, rb.drainedErr()
+			}
+			rb.prefillBuffer()
+			continue
+		}
+		m, _ := rb.unlockedDiscard(n - discarded)
+		discarded += m
+	}
+	return discarded, nil
+}
+
+func (br *BufioReader) UnreadByte() error {
+	return bufioErr(br.RingBuffer.UnreadByte())
+}
+
+func (br *BufioReader) UnreadRune() error {
+	return bufioErr(br.RingBuffer.UnreadRune())
+}
+
+// ReadSlice reads until the first occurrence of delim and returns a slice
+// that is only valid until the next call, or bufio.ErrBufferFull with the
+// whole buffer if delim is not found in it.
+func (br *BufioReader) ReadSlice(delim byte) ([]byte, error) {
+	rb := br.RingBuffer
+	rb.lock()
+	defer rb.unlock()
+
+	scanned := 0
+	for {
+		if i := rb.indexByte(delim, scanned); i >= 0 {
+			return rb.view(i + 1)
+		}
+		if !rb.refillable() || rb.unlockedCapacity() == 0 {
+			break
+		}
+		scanned = rb.unlockedLen()
+		rb.prefillBuffer()
+	}
+
+	n := rb.unlockedLen()
+	if rb.refillable() {
+		line, err := rb.view(n)
+		if err == nil {
+			err = bufio.ErrBufferFull
+		}
+		return line, err
+	}
+	if n == 0 {
+		return nil, rb.drainedErr()
+	}
+	line, err := rb.view(n)
+	if err == nil {
+		err = rb.drainedErr()
+	}
+	return line, err
+}
+
+// Buffered returns the number of bytes that can be read without refilling.
+func (br *BufioReader) Buffered() int {
+	return br.RingBuffer.Len()
+}
+
+func (br *BufioReader) Size() int {
+	return br.RingBuffer.Cap()
+}
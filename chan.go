@@ -0,0 +1,104 @@
+/*
+ * Copyright (c) 2023 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package ringbuffer
+
+// WithChanChunkSize sets the size of the chunks sent by Chan, which
+// defaults to the buffer capacity.
+func WithChanChunkSize(n int) Option {
+	return func(rb *RingBuffer) {
+		rb.chanChunkSize = n
+	}
+}
+
+// WithChanReuse makes Chan alternate between two chunk buffers instead of
+// allocating one per chunk: a received chunk then remains valid only until
+// the next one is received.
+func WithChanReuse() Option {
+	return func(rb *RingBuffer) {
+		rb.chanReuse = true
+	}
+}
+
+// Chan starts a goroutine draining the buffer and its reader into the
+// returned channel, one chunk at a time, and closes it once the source is
+// exhausted or CloseChan is called. Unless WithChanReuse is set, each chunk
+// is freshly allocated and owned by the receiver. The buffer must not be
+// read through other means while the goroutine runs.
+func (rb *RingBuffer) Chan() <-chan []byte {
+	rb.lock()
+	defer rb.unlock()
+
+	if rb.ch != nil {
+		return rb.ch
+	}
+
+	size := rb.chanChunkSize
+	if size <= 0 {
+		size = cap(rb.buffer)
+	}
+	reuse := rb.chanReuse
+
+	ch := make(chan []byte)
+	done := make(chan struct{})
+	rb.ch = ch
+	rb.chDone = done
+
+	go func() {
+		defer close(ch)
+
+		var chunks [2][]byte
+		for i := 0; ; i++ {
+			var chunk []byte
+			if reuse {
+				if chunks[i%2] == nil {
+					chunks[i%2] = make([]byte, size)
+				}
+				chunk = chunks[i%2]
+			} else {
+				chunk = make([]byte, size)
+			}
+
+			n, err := rb.Read(chunk)
+			if n != 0 {
+				select {
+				case ch <- chunk[:n]:
+				case <-done:
+					return
+				}
+			}
+			if err != nil || n == 0 {
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// CloseChan stops the goroutine started by Chan. A Read already blocked on
+// the underlying reader is not interrupted. A later Chan starts a new
+// goroutine, which should wait until the previous channel is closed so
+// that the two do not read at the same time.
+func (rb *RingBuffer) CloseChan() {
+	rb.lock()
+	defer rb.unlock()
+
+	if rb.chDone != nil {
+		close(rb.chDone)
+		rb.chDone = nil
+		rb.ch = nil
+	}
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright (c) 2023 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package ringbuffer
+
+import "hash"
+
+// WithChecksum feeds h with every byte delivered by the reading methods,
+// delimiters included, and WriteTo, so that the digest of the consumed
+// stream is available from Checksum without a second pass. Discarded bytes
+// are not included.
+func WithChecksum(h hash.Hash) Option {
+	return func(rb *RingBuffer) {
+		rb.checksum = h
+	}
+}
+
+// Checksum returns the current digest of the bytes delivered so far, or nil
+// if the buffer was not created with WithChecksum.
+func (rb *RingBuffer) Checksum() []byte {
+	rb.lock()
+	defer rb.unlock()
+
+	if rb.checksum == nil {
+		return nil
+	}
+	return rb.checksum.Sum(nil)
+}
+
+// ReadHashed is like Read but also feeds h with the bytes read, straight
+// from the internal buffer.
+func (rb *RingBuffer) ReadHashed(p []byte, h hash.Hash) (int, error) {
+	rb.lock()
+	defer rb.unlock()
+
+	rb.refillLowWater()
+	if len(p) > rb.unlockedLen() && rb.refillable() {
+		rb.prefillBuffer()
+	}
+
+	n := len(p)
+	if rblen := rb.unlockedLen(); n > rblen {
+		n = rblen
+	}
+	first, second := rb.slices(rb.head, n)
+	h.Write(first)
+	h.Write(second)
+
+	n, err := rb.consume(p[:n])
+	if err != nil || n != 0 {
+		return n, err
+	}
+	return n, rb.takeErr()
+}
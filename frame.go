@@ -0,0 +1,124 @@
+/*
+ * Copyright (c) 2023 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package ringbuffer
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	frameHeaderSize     = 4
+	DefaultMaxFrameSize = 16 << 20
+)
+
+var ErrFrameTooLarge = errors.New("ringbuffer: frame too large")
+
+// WithMaxFrameSize bounds the payload length ReadFrame accepts, instead of
+// DefaultMaxFrameSize.
+func WithMaxFrameSize(n int) Option {
+	return func(rb *RingBuffer) {
+		rb.maxFrameSize = n
+	}
+}
+
+func (rb *RingBuffer) frameLimit() int {
+	if rb.maxFrameSize > 0 {
+		return rb.maxFrameSize
+	}
+	return DefaultMaxFrameSize
+}
+
+// ReadFrame reads a payload prefixed by its length as a 4-byte integer in
+// the given byte order, growing the buffer if the frame does not fit.
+func (rb *RingBuffer) ReadFrame(order binary.ByteOrder) ([]byte, error) {
+	rb.lock()
+	defer rb.unlock()
+
+	if rblen := rb.fill(frameHeaderSize); rblen < frameHeaderSize {
+		if rblen == 0 {
+			return nil, rb.drainedErr()
+		}
+		return nil, rb.truncatedErr()
+	}
+
+	var header [frameHeaderSize]byte
+	rb.copyToBuffer(header[:], rb.head)
+	length := order.Uint32(header[:])
+	if uint64(length) > uint64(rb.frameLimit()) {
+		return nil, ErrFrameTooLarge
+	}
+
+	total := frameHeaderSize + int(length)
+	if total+rb.history > cap(rb.buffer) {
+		rb.resize(total + rb.history)
+	}
+	if rb.fill(total) < total {
+		return nil, rb.truncatedErr()
+	}
+
+	payload := make([]byte, length)
+	rb.copyToBuffer(payload, (rb.head+frameHeaderSize)%cap(rb.buffer))
+	err := rb.deliver(total)
+	rb.stats.Reads++
+	return payload, err
+}
+
+// truncatedErr is the error reported when the data ends in the middle of a
+// frame.
+func (rb *RingBuffer) truncatedErr() error {
+	if err := rb.drainedErr(); err != io.EOF {
+		return err
+	}
+	return io.ErrUnexpectedEOF
+}
+
+// PeekFrames returns the payloads of up to max complete frames from the
+// buffered data, without refilling or consuming them; max <= 0 means no
+// limit. Frames are returned as slices of the internal buffer unless they
+// wrap around, in which case they are copied. Once processed, they can be
+// consumed by discarding 4 bytes plus the payload length for each frame.
+func (rb *RingBuffer) PeekFrames(order binary.ByteOrder, max int) ([][]byte, error) {
+	rb.lock()
+	defer rb.unlock()
+
+	var frames [][]byte
+
+	rblen := rb.unlockedLen()
+	off := 0
+	for (max <= 0 || len(frames) < max) && rblen-off >= frameHeaderSize {
+		var header [frameHeaderSize]byte
+		rb.copyToBuffer(header[:], (rb.head+off)%cap(rb.buffer))
+		length := order.Uint32(header[:])
+		if uint64(length) > uint64(rb.frameLimit()) {
+			return frames, ErrFrameTooLarge
+		}
+		if uint64(rblen-off-frameHeaderSize) < uint64(length) {
+			break
+		}
+
+		first, second := rb.slices((rb.head+off+frameHeaderSize)%cap(rb.buffer), int(length))
+		if len(second) != 0 {
+			first = append(append(make([]byte, 0, length), first...), second...)
+		}
+		frames = append(frames, first)
+		off += frameHeaderSize + int(length)
+	}
+	rb.lastByte = false
+	return frames, nil
+}
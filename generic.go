@@ -0,0 +1,81 @@
+/*
+ * Copyright (c) 2023 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package ringbuffer
+
+// Ring is the element-typed counterpart of RingBuffer. It cannot be named
+// RingBuffer as well since both live in this package.
+type Ring[T any] struct {
+	buffer []T
+	head   int
+	tail   int
+
+	filled bool
+}
+
+func NewRing[T any](size int) *Ring[T] {
+	return &Ring[T]{
+		buffer: make([]T, size),
+	}
+}
+
+func (r *Ring[T]) Len() int {
+	if r.filled {
+		return len(r.buffer)
+	}
+
+	delta := r.tail - r.head
+	if delta < 0 {
+		return len(r.buffer) + delta
+	}
+	return delta
+}
+
+func (r *Ring[T]) Cap() int {
+	return len(r.buffer)
+}
+
+func (r *Ring[T]) Push(v T) bool {
+	if r.filled || len(r.buffer) == 0 {
+		return false
+	}
+
+	r.buffer[r.tail] = v
+	r.tail = (r.tail + 1) % len(r.buffer)
+	r.filled = r.head == r.tail
+	return true
+}
+
+func (r *Ring[T]) Pop() (T, bool) {
+	var zero T
+	if r.Len() == 0 {
+		return zero, false
+	}
+
+	v := r.buffer[r.head]
+	r.buffer[r.head] = zero
+	r.head = (r.head + 1) % len(r.buffer)
+	r.filled = false
+	return v, true
+}
+
+func (r *Ring[T]) PeekAt(i int) (T, bool) {
+	var zero T
+	if i < 0 || i >= r.Len() {
+		return zero, false
+	}
+	return r.buffer[(r.head+i)%len(r.buffer)], true
+}
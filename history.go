@@ -0,0 +1,76 @@
+/*
+ * Copyright (c) 2023 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package ringbuffer
+
+import (
+	"errors"
+	"io"
+)
+
+var ErrSeekOutOfRange = errors.New("ringbuffer: seek out of range")
+
+// WithHistory keeps up to n already consumed bytes around so that Seek can
+// move back over them. The backing array is enlarged by n so the usable
+// capacity is unchanged.
+func WithHistory(n int) Option {
+	return func(rb *RingBuffer) {
+		rb.history = n
+		rb.buffer = make([]byte, cap(rb.buffer)+n)
+	}
+}
+
+func NewWithHistory(rd io.Reader, size int, history int) *RingBuffer {
+	return New(size, WithReader(rd), WithHistory(history))
+}
+
+// Seek moves the read position within the retained history, backward, or
+// the buffered bytes, forward. Positions are counted from the first byte
+// ever consumed; io.SeekEnd is not supported since the stream length is
+// not known. Moving backward does not rewind the rolling hash: Fingerprint
+// keeps covering the bytes consumed before the Seek.
+func (rb *RingBuffer) Seek(offset int64, whence int) (int64, error) {
+	rb.lock()
+	defer rb.unlock()
+
+	switch whence {
+	case io.SeekStart:
+		offset -= rb.pos
+	case io.SeekCurrent:
+	default:
+		return rb.pos, ErrSeekOutOfRange
+	}
+
+	switch {
+	case offset < 0:
+		if -offset > int64(rb.retained) {
+			return rb.pos, ErrSeekOutOfRange
+		}
+		n := int(-offset)
+		rb.head = (rb.head + cap(rb.buffer) - n) % cap(rb.buffer)
+		rb.filled = rb.head == rb.tail
+		rb.retained -= n
+		rb.pos -= int64(n)
+		rb.lastByte = false
+		rb.runeSize = 0
+	case offset > 0:
+		if offset > int64(rb.unlockedLen()) {
+			return rb.pos, ErrSeekOutOfRange
+		}
+		rb.unlockedDiscard(int(offset))
+	}
+	return rb.pos, nil
+}
@@ -0,0 +1,62 @@
+/*
+ * Copyright (c) 2023 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package ringbuffer
+
+import "errors"
+
+var ErrMarkInvalidated = errors.New("ringbuffer: mark invalidated")
+
+// Mark returns a token for the current read position that Restore can
+// rewind to, for as long as the bytes consumed since have not been
+// overwritten by writes or refills. Tokens do not survive Reset.
+func (rb *RingBuffer) Mark() int {
+	rb.lock()
+	defer rb.unlock()
+	return int(rb.pos)
+}
+
+// Restore rewinds the read position to token, making the bytes consumed
+// since Mark readable again, or fails with ErrMarkInvalidated if some of
+// them have been overwritten since or the buffer was resized.
+func (rb *RingBuffer) Restore(token int) error {
+	rb.lock()
+	defer rb.unlock()
+
+	n := int(rb.pos) - token
+	switch {
+	case n == 0:
+		return nil
+	case n < 0 || int64(token) < rb.markBase || rb.zeroOnDiscard:
+		return ErrMarkInvalidated
+	}
+
+	// writes only ever shrink the free space before head, and consuming
+	// grows it along with n, so it stays at least n until the rewound
+	// bytes start being overwritten
+	if n > cap(rb.buffer)-rb.unlockedLen() {
+		return ErrMarkInvalidated
+	}
+	rb.head = (rb.head + cap(rb.buffer) - n) % cap(rb.buffer)
+	rb.filled = rb.head == rb.tail
+	rb.retained -= n
+	if rb.retained < 0 {
+		rb.retained = 0
+	}
+	rb.pos -= int64(n)
+	rb.lastByte = false
+	return nil
+}
@@ -0,0 +1,81 @@
+/*
+ * Copyright (c) 2023 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package ringbuffer
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var ErrInvalidEncoding = errors.New("ringbuffer: invalid binary encoding")
+
+// MarshalBinary encodes the buffer geometry followed by the raw content of
+// the backing array. The attached reader, if any, is not part of it.
+func (rb *RingBuffer) MarshalBinary() ([]byte, error) {
+	rb.lock()
+	defer rb.unlock()
+
+	data := make([]byte, 0, 3*binary.MaxVarintLen64+1+cap(rb.buffer))
+	data = binary.AppendUvarint(data, uint64(cap(rb.buffer)))
+	data = binary.AppendUvarint(data, uint64(rb.head))
+	data = binary.AppendUvarint(data, uint64(rb.tail))
+	if rb.filled {
+		data = append(data, 1)
+	} else {
+		data = append(data, 0)
+	}
+	return append(data, rb.buffer...), nil
+}
+
+// UnmarshalBinary restores a buffer encoded by MarshalBinary. It is left
+// without a reader.
+func (rb *RingBuffer) UnmarshalBinary(data []byte) error {
+	rb.lock()
+	defer rb.unlock()
+
+	var fields [3]uint64
+	for i := range fields {
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			return ErrInvalidEncoding
+		}
+		fields[i] = v
+		data = data[n:]
+	}
+	size, head, tail := fields[0], fields[1], fields[2]
+
+	if len(data) == 0 || data[0] > 1 || uint64(len(data)-1) != size {
+		return ErrInvalidEncoding
+	}
+	filled := data[0] == 1
+	if size == 0 || head >= size || tail >= size || filled && head != tail {
+		return ErrInvalidEncoding
+	}
+
+	rb.buffer = append([]byte(nil), data[1:]...)
+	rb.head = int(head)
+	rb.tail = int(tail)
+	rb.filled = filled
+	rb.lastByte = false
+	rb.retained = 0
+	rb.markBase = rb.pos
+	rb.rd = nil
+	rb.rdErr = nil
+	rb.pending = nil
+	rb.signalNotFull()
+	return nil
+}
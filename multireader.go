@@ -0,0 +1,66 @@
+/*
+ * Copyright (c) 2023 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package ringbuffer
+
+import "io"
+
+// WithReaders reads from each reader in turn, moving to the next one when
+// the current one returns io.EOF, so that io.EOF is only reported after
+// the last.
+func WithReaders(readers ...io.Reader) Option {
+	return func(rb *RingBuffer) {
+		rb.readers = readers
+		rb.current = 0
+		rb.rd = nil
+		if len(readers) != 0 {
+			rb.rd = readers[0]
+		}
+	}
+}
+
+func NewMultiReaderSize(size int, readers ...io.Reader) *RingBuffer {
+	return New(size, WithReaders(readers...))
+}
+
+// AppendReader queues r after the readers already attached. If they are
+// all exhausted, refills resume from r.
+func (rb *RingBuffer) AppendReader(r io.Reader) {
+	rb.lock()
+	defer rb.unlock()
+
+	if rb.readers == nil && rb.rd != nil {
+		rb.readers = []io.Reader{rb.rd}
+	}
+	rb.readers = append(rb.readers, r)
+	if rb.rd == nil && (rb.rdErr == nil || rb.rdErr == io.EOF) {
+		rb.current = len(rb.readers) - 1
+		rb.rd = r
+		rb.rdErr = nil
+		rb.signalNotEmpty()
+	}
+}
+
+// nextReader moves on to the reader following the current one, if any.
+func (rb *RingBuffer) nextReader() bool {
+	if rb.current+1 >= len(rb.readers) || (rb.limited && rb.remaining == 0) {
+		return false
+	}
+	rb.readers[rb.current] = nil
+	rb.current++
+	rb.rd = rb.readers[rb.current]
+	return true
+}
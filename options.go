@@ -0,0 +1,112 @@
+/*
+ * Copyright (c) 2023 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package ringbuffer
+
+import (
+	"io"
+	"sync"
+)
+
+type Option func(*RingBuffer)
+
+func WithReader(rd io.Reader) Option {
+	return func(rb *RingBuffer) {
+		rb.rd = rd
+	}
+}
+
+// WithOverwrite makes writes never fall short: when there is not enough
+// room, the oldest buffered bytes are dropped to make space, and if p alone
+// exceeds the capacity only its last Cap() bytes are kept.
+func WithOverwrite() Option {
+	return func(rb *RingBuffer) {
+		rb.overwrite = true
+	}
+}
+
+// WithLocking guards every method with a mutex so that a producer and a
+// consumer goroutine can share the buffer.
+func WithLocking() Option {
+	return func(rb *RingBuffer) {
+		rb.mu = &sync.Mutex{}
+		rb.notEmpty = sync.NewCond(rb.mu)
+		rb.notFull = sync.NewCond(rb.mu)
+	}
+}
+
+// WithEagerFill makes each refill keep reading until the free space is full
+// or the reader fails, instead of settling for whatever a single Read
+// returned.
+func WithEagerFill() Option {
+	return func(rb *RingBuffer) {
+		rb.eager = true
+	}
+}
+
+// WithMaxEmptyReads sets how many consecutive reads returning no data and
+// no error are tolerated from the reader, instead of DefaultMaxEmptyReads,
+// before refills fail with io.ErrNoProgress.
+func WithMaxEmptyReads(n int) Option {
+	return func(rb *RingBuffer) {
+		rb.maxEmptyReads = n
+	}
+}
+
+// WithLimit stops refilling after limit bytes have been pulled from the
+// source, which then counts as having reached io.EOF, as with an
+// io.LimitReader.
+func WithLimit(limit int64) Option {
+	return func(rb *RingBuffer) {
+		rb.limited = true
+		rb.remaining = limit
+	}
+}
+
+// WithLowWater makes Read and ReadByte refill from the reader as soon as
+// fewer than n bytes are buffered, rather than waiting for the buffer to
+// run short of a request.
+func WithLowWater(n int) Option {
+	return func(rb *RingBuffer) {
+		rb.lowWater = n
+	}
+}
+
+// WithHighWater bounds how much the refills triggered by WithLowWater
+// buffer, instead of the whole capacity.
+func WithHighWater(n int) Option {
+	return func(rb *RingBuffer) {
+		rb.highWater = n
+	}
+}
+
+// WithTee forwards all bytes delivered by the reading methods, delimiters
+// included, and WriteTo to w, like io.TeeReader.
+func WithTee(w io.Writer) Option {
+	return func(rb *RingBuffer) {
+		rb.tee = w
+	}
+}
+
+// WithZeroOnDiscard wipes bytes from the backing array as soon as they are
+// consumed, so that secrets do not linger in memory. It costs a pass over
+// every consumed span, prevents UnreadByte and leaves zeros in place of
+// the retained bytes of WithHistory.
+func WithZeroOnDiscard() Option {
+	return func(rb *RingBuffer) {
+		rb.zeroOnDiscard = true
+	}
+}
@@ -0,0 +1,65 @@
+/*
+ * Copyright (c) 2023 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package ringbuffer
+
+import (
+	"sync"
+)
+
+// pools recycles backing arrays, with one sync.Pool per size.
+var pools sync.Map
+
+func poolFor(size int) *sync.Pool {
+	if pool, ok := pools.Load(size); ok {
+		return pool.(*sync.Pool)
+	}
+	pool, _ := pools.LoadOrStore(size, &sync.Pool{
+		New: func() any {
+			buffer := make([]byte, size)
+			return &buffer
+		},
+	})
+	return pool.(*sync.Pool)
+}
+
+// NewPooled is like New but takes its backing array from a pool of
+// previously released ones of the same size.
+func NewPooled(size int) *RingBuffer {
+	mustBePositive(size)
+	return &RingBuffer{
+		buffer: *poolFor(size).Get().(*[]byte),
+	}
+}
+
+// Release returns the backing array to the pool used by NewPooled. The
+// buffer must not be used afterwards.
+func (rb *RingBuffer) Release() {
+	rb.lock()
+	defer rb.unlock()
+
+	buffer := rb.buffer
+	rb.buffer = nil
+	rb.rd = nil
+	rb.rdErr = nil
+	rb.pending = nil
+	rb.head = 0
+	rb.tail = 0
+	rb.filled = false
+	rb.lastByte = false
+	rb.retained = 0
+	poolFor(cap(buffer)).Put(&buffer)
+}
@@ -0,0 +1,147 @@
+/*
+ * Copyright (c) 2023 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package ringbuffer
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// tokenBucket meters how many bytes may be pulled from the reader, with a
+// burst of up to one second worth of bytes.
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(bytesPerSec int) *tokenBucket {
+	return &tokenBucket{
+		rate:   float64(bytesPerSec),
+		tokens: float64(bytesPerSec),
+		last:   time.Now(),
+	}
+}
+
+func (tb *tokenBucket) refill() {
+	now := time.Now()
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > tb.rate {
+		tb.tokens = tb.rate
+	}
+	tb.last = now
+}
+
+// delay returns how long to wait until at least one byte may be read.
+func (tb *tokenBucket) delay() time.Duration {
+	if tb.rate <= 0 {
+		return 0
+	}
+
+	tb.refill()
+	if tb.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - tb.tokens) / tb.rate * float64(time.Second))
+}
+
+// grant returns how many of the n requested bytes may be read now.
+func (tb *tokenBucket) grant(n int) int {
+	if tb.rate <= 0 {
+		return n
+	}
+
+	tb.refill()
+	if granted := int(tb.tokens); granted < n {
+		n = granted
+	}
+	if n < 0 {
+		n = 0
+	}
+	return n
+}
+
+// charge deducts the n bytes actually read from the budget.
+func (tb *tokenBucket) charge(n int) {
+	if tb.rate > 0 {
+		tb.tokens -= float64(n)
+	}
+}
+
+// WithRateLimit throttles refills to bytesPerSec bytes per second. Reads
+// needing a refill block until enough budget is available, or until the
+// context of ReadContext and similar calls is done. With WithLocking, other
+// calls wait for them meanwhile.
+func WithRateLimit(bytesPerSec int) Option {
+	return func(rb *RingBuffer) {
+		rb.limiter = newTokenBucket(bytesPerSec)
+	}
+}
+
+func NewRateLimitedReader(rd io.Reader, size int, bytesPerSec int) *RingBuffer {
+	return New(size, WithReader(rd), WithRateLimit(bytesPerSec))
+}
+
+// SetRate changes the refill rate limit; a rate <= 0 removes it.
+func (rb *RingBuffer) SetRate(bytesPerSec int) {
+	rb.lock()
+	defer rb.unlock()
+
+	if rb.limiter == nil {
+		rb.limiter = newTokenBucket(bytesPerSec)
+		return
+	}
+	rb.limiter.refill()
+	rb.limiter.rate = float64(bytesPerSec)
+	if rb.limiter.tokens > rb.limiter.rate {
+		rb.limiter.tokens = rb.limiter.rate
+	}
+}
+
+// limit trims p to the budget allowed by the rate limiter, if any.
+func (rb *RingBuffer) limit(p []byte) []byte {
+	if rb.limiter == nil {
+		return p
+	}
+	return p[:rb.limiter.grant(len(p))]
+}
+
+// throttle waits until the rate limiter allows reading at least one byte
+// and reports whether it does before the context of the call is done. The
+// lock stays held, as callers rely on the buffer not changing under them.
+func (rb *RingBuffer) throttle() bool {
+	ctx := rb.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	for {
+		d := rb.limiter.delay()
+		if d <= 0 {
+			return true
+		}
+
+		timer := time.NewTimer(d)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		}
+	}
+}
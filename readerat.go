@@ -0,0 +1,75 @@
+/*
+ * Copyright (c) 2023 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package ringbuffer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+var ErrNoReaderAt = errors.New("ringbuffer: source is not an io.ReaderAt")
+
+// WithReaderAt refills from ra, starting at offset and moving forward, so
+// that SeekSource can later move to another offset. It panics if offset is
+// negative.
+func WithReaderAt(ra io.ReaderAt, offset int64) Option {
+	if offset < 0 {
+		panic(fmt.Sprintf("ringbuffer: invalid offset %d, must not be negative", offset))
+	}
+	return func(rb *RingBuffer) {
+		rb.section = io.NewSectionReader(ra, 0, math.MaxInt64)
+		rb.section.Seek(offset, io.SeekStart)
+		rb.rd = rb.section
+		rb.pos = offset
+		rb.markBase = offset
+	}
+}
+
+func NewReaderAtSize(ra io.ReaderAt, offset int64, size int) *RingBuffer {
+	return New(size, WithReaderAt(ra, offset))
+}
+
+// SeekSource drops the buffered bytes and resumes refilling from offset in
+// the io.ReaderAt set with WithReaderAt. Positions reported by Seek then
+// count from the start of the source.
+func (rb *RingBuffer) SeekSource(offset int64) error {
+	rb.lock()
+	defer rb.unlock()
+
+	if rb.section == nil {
+		return ErrNoReaderAt
+	}
+	if _, err := rb.section.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	rb.rd = rb.section
+	rb.rdErr = nil
+	rb.pending = nil
+	rb.head = 0
+	rb.tail = 0
+	rb.filled = false
+	rb.lastByte = false
+	rb.pos = offset
+	rb.retained = 0
+	rb.markBase = offset
+	rb.signalNotEmpty()
+	rb.signalNotFull()
+	return nil
+}
@@ -17,39 +17,366 @@
 package ringbuffer
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash"
 	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 )
 
+var (
+	ErrInvalidUnreadByte = errors.New("ringbuffer: invalid use of UnreadByte")
+	ErrInvalidUnreadRune = errors.New("ringbuffer: invalid use of UnreadRune")
+	ErrClosed            = errors.New("ringbuffer: closed")
+	ErrNegativeCount     = errors.New("ringbuffer: negative count")
+	ErrOutOfWindow       = errors.New("ringbuffer: offset outside of buffered window")
+	ErrBufferFull        = errors.New("ringbuffer: buffer full")
+
+	// ErrRead wraps the errors of the reader, other than io.EOF, so that
+	// both can be matched with errors.Is.
+	ErrRead = errors.New("ringbuffer: read from source")
+)
+
+// DefaultMaxEmptyReads is how many consecutive reads returning no data and
+// no error are tolerated from the reader before giving up.
+const DefaultMaxEmptyReads = 100
+
 type RingBuffer struct {
 	rd    io.Reader
 	rdErr error
+	wr    io.Writer
+
+	// transient reader error, reported once by the next call that finds
+	// nothing buffered, after which the reader is retried
+	pending   error
+	retryable func(error) bool
 
 	buffer []byte
 	head   int
 	tail   int
 
-	filled bool
+	filled   bool
+	lastByte bool
+
+	// size of the rune returned by the last call if it was ReadRune
+	runeSize int
+
+	readers []io.Reader
+	current int
+	section *io.SectionReader
+
+	limited   bool
+	remaining int64
+
+	pos      int64
+	history  int
+	retained int
+	markBase int64
+
+	overwrite     bool
+	autoFlush     bool
+	eager         bool
+	maxEmptyReads int
+	zeroOnDiscard bool
+	maxFrameSize  int
+	lowWater      int
+	highWater     int
+
+	scratch  []byte
+	hash     *buzhash
+	tee      io.Writer
+	checksum hash.Hash
+
+	stats   Stats
+	limiter *tokenBucket
+
+	ch            chan []byte
+	chDone        chan struct{}
+	chanChunkSize int
+	chanReuse     bool
+
+	// context of the blocking call in progress, if any
+	ctx      context.Context
+	deadline time.Time
+
+	mu       *sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
 }
 
-func New(size int) *RingBuffer {
-	return &RingBuffer{
+// New returns a buffer of size bytes configured by opts. It panics if size
+// is not positive, as a buffer that cannot hold a byte could never make
+// progress.
+func New(size int, opts ...Option) *RingBuffer {
+	mustBePositive(size)
+	rb := &RingBuffer{
 		buffer: make([]byte, size),
 	}
+	for _, opt := range opts {
+		opt(rb)
+	}
+	return rb
+}
+
+func mustBePositive(size int) {
+	if size <= 0 {
+		panic(fmt.Sprintf("ringbuffer: invalid size %d, must be positive", size))
+	}
 }
 
 func NewReaderSize(rd io.Reader, size int) *RingBuffer {
-	rb := New(size)
+	return New(size, WithReader(rd))
+}
+
+func NewReaderSizeEager(rd io.Reader, size int) *RingBuffer {
+	return New(size, WithReader(rd), WithEagerFill())
+}
+
+func NewLimitedReaderSize(rd io.Reader, size int, limit int64) *RingBuffer {
+	return New(size, WithReader(rd), WithLimit(limit))
+}
+
+func NewOverwriting(size int) *RingBuffer {
+	return New(size, WithOverwrite())
+}
+
+func NewSync(size int) *RingBuffer {
+	return New(size, WithLocking())
+}
+
+func NewSyncReaderSize(rd io.Reader, size int) *RingBuffer {
+	return New(size, WithReader(rd), WithLocking())
+}
+
+func (rb *RingBuffer) lock() {
+	if rb.mu != nil {
+		rb.mu.Lock()
+	}
+}
+
+func (rb *RingBuffer) unlock() {
+	if rb.mu != nil {
+		rb.mu.Unlock()
+	}
+}
+
+func (rb *RingBuffer) Reset(rd io.Reader) {
+	rb.lock()
+	defer rb.unlock()
+
 	rb.rd = rd
-	return rb
+	rb.readers = nil
+	rb.current = 0
+	rb.section = nil
+	rb.rdErr = nil
+	rb.pending = nil
+	rb.head = 0
+	rb.tail = 0
+	rb.filled = false
+	rb.lastByte = false
+	rb.runeSize = 0
+	rb.pos = 0
+	rb.retained = 0
+	rb.markBase = 0
+	rb.signalNotFull()
+}
+
+// Clone returns an independent copy of the buffered bytes and settings.
+// The clone has no reader: it neither shares nor advances the source, and
+// reports io.EOF once its copy is drained, so it can be consumed without
+// affecting rb.
+func (rb *RingBuffer) Clone() *RingBuffer {
+	rb.lock()
+	defer rb.unlock()
+
+	clone := &RingBuffer{
+		rdErr:         io.EOF,
+		buffer:        make([]byte, cap(rb.buffer)),
+		head:          rb.head,
+		tail:          rb.tail,
+		filled:        rb.filled,
+		lastByte:      rb.lastByte,
+		pos:           rb.pos,
+		history:       rb.history,
+		retained:      rb.retained,
+		markBase:      rb.markBase,
+		overwrite:     rb.overwrite,
+		eager:         rb.eager,
+		maxEmptyReads: rb.maxEmptyReads,
+		limited:       rb.limited,
+		remaining:     rb.remaining,
+		retryable:     rb.retryable,
+		zeroOnDiscard: rb.zeroOnDiscard,
+		maxFrameSize:  rb.maxFrameSize,
+		lowWater:      rb.lowWater,
+		highWater:     rb.highWater,
+		stats:         rb.stats,
+	}
+	copy(clone.buffer, rb.buffer[:cap(rb.buffer)])
+	if rb.hash != nil {
+		hash := *rb.hash
+		hash.window = append([]byte(nil), rb.hash.window...)
+		clone.hash = &hash
+	}
+	if rb.mu != nil {
+		WithLocking()(clone)
+	}
+	return clone
+}
+
+// Err returns the error of the reader, including a transient one still to
+// be reported, without clearing it.
+func (rb *RingBuffer) Err() error {
+	rb.lock()
+	defer rb.unlock()
+
+	if rb.pending != nil {
+		return rb.pending
+	}
+	return rb.rdErr
+}
+
+// SourceExhausted reports whether the reader is done, having returned
+// io.EOF or another error, so that nothing but the bytes still buffered
+// will ever be read, unless a new reader is attached.
+func (rb *RingBuffer) SourceExhausted() bool {
+	rb.lock()
+	defer rb.unlock()
+	return rb.rd == nil && rb.rdErr != nil
+}
+
+func (rb *RingBuffer) ClearErr() {
+	rb.lock()
+	defer rb.unlock()
+	rb.rdErr = nil
+	rb.pending = nil
+}
+
+// SetReader attaches rd as the source for subsequent refills, keeping the
+// bytes already buffered, so that sources can be chained once the previous
+// one reached io.EOF. Call ClearErr first to resume after any other error.
+func (rb *RingBuffer) SetReader(rd io.Reader) {
+	rb.lock()
+	defer rb.unlock()
+
+	rb.rd = rd
+	rb.readers = nil
+	rb.current = 0
+	rb.section = nil
+	if rb.rdErr == io.EOF {
+		rb.rdErr = nil
+	}
+	rb.signalNotEmpty()
+}
+
+// Close closes the underlying reader if it is an io.Closer. Bytes already
+// buffered can still be read, after which reads return ErrClosed.
+func (rb *RingBuffer) Close() error {
+	rb.lock()
+	defer rb.unlock()
+
+	var err error
+	if closer, ok := rb.rd.(io.Closer); ok {
+		err = closer.Close()
+	}
+	rb.rd = nil
+	rb.rdErr = ErrClosed
+	rb.signalNotEmpty()
+	rb.signalNotFull()
+	return err
+}
+
+// readSource reads from the reader into p, never past the limit set with
+// WithLimit, which then ends the source with io.EOF.
+func (rb *RingBuffer) readSource(p []byte) (int, error) {
+	if rb.limited {
+		if rb.remaining == 0 {
+			return 0, io.EOF
+		}
+		if int64(len(p)) > rb.remaining {
+			p = p[:rb.remaining]
+		}
+	}
+
+	n, err := rb.pull(p)
+	if rb.limited {
+		rb.remaining -= int64(n)
+		if rb.remaining == 0 && err == nil {
+			err = io.EOF
+		}
+	}
+	return n, err
+}
+
+// pull reads from the reader into p, insisting until p is full when the
+// buffer was created with WithEagerFill. A reader that keeps returning no
+// data and no error fails with io.ErrNoProgress, like in bufio.
+func (rb *RingBuffer) pull(p []byte) (int, error) {
+	if p = rb.limit(p); len(p) == 0 {
+		return 0, nil
+	}
+
+	n := 0
+	for empty := 0; n < len(p); {
+		m, err := rb.rd.Read(p[n:])
+		if rb.limiter != nil {
+			rb.limiter.charge(m)
+		}
+		rb.stats.SourceReads++
+		rb.stats.SourceBytes += uint64(m)
+		n += m
+		if err != nil {
+			return n, err
+		}
+		if m == 0 {
+			if n != 0 {
+				break
+			}
+			if empty++; empty >= rb.emptyReadLimit() {
+				return 0, io.ErrNoProgress
+			}
+			continue
+		}
+		if !rb.eager {
+			break
+		}
+	}
+	return n, nil
+}
+
+func (rb *RingBuffer) emptyReadLimit() int {
+	if rb.maxEmptyReads > 0 {
+		return rb.maxEmptyReads
+	}
+	return DefaultMaxEmptyReads
 }
 
 func (rb *RingBuffer) prefillBuffer() int {
+	return rb.prefillUpTo(cap(rb.buffer))
+}
+
+// prefillUpTo refills from the reader without buffering more than limit
+// bytes in total.
+func (rb *RingBuffer) prefillUpTo(limit int) int {
 	totalCapacity := rb.unlockedCapacity()
 	totalLen := rb.unlockedLen()
+	if limit-totalLen < totalCapacity {
+		totalCapacity = limit - totalLen
+	}
 
-	if rb.rd == nil || rb.rdErr != nil || totalCapacity == 0 {
+	if !rb.refillable() || totalCapacity <= 0 {
 		return totalLen
 	}
+	if rb.limiter != nil && !rb.throttle() {
+		return totalLen
+	}
+	rb.stats.Refills++
 
 	var rCapacity int
 	if rb.tail < rb.head {
@@ -57,69 +384,274 @@ func (rb *RingBuffer) prefillBuffer() int {
 	} else {
 		rCapacity = cap(rb.buffer) - rb.tail
 	}
-
-	n, err := rb.rd.Read(rb.buffer[rb.tail : rb.tail+rCapacity])
-	if err != nil && err != io.EOF {
-		rb.rd = nil
-		rb.rdErr = err
-		return totalLen
+	if rCapacity > totalCapacity {
+		rCapacity = totalCapacity
 	}
+
+	n, err := rb.readSource(rb.buffer[rb.tail : rb.tail+rCapacity])
 	if n != 0 {
 		rb.tail = (rb.tail + n) % cap(rb.buffer)
+		rb.lastByte = false
 		totalLen += n
 	}
+	if err == io.EOF && rb.nextReader() {
+		if n == 0 {
+			return rb.prefillUpTo(limit)
+		}
+		err = nil
+	}
 
-	if rCapacity < totalCapacity && err != io.EOF {
+	if err == nil && n == rCapacity && rCapacity < totalCapacity {
 		lCapacity := totalCapacity - rCapacity
-		n, err := rb.rd.Read(rb.buffer[rb.tail : rb.tail+lCapacity])
-		if err != nil && err != io.EOF {
-			rb.rd = nil
-			rb.rdErr = err
-			return totalLen
-		}
+		n, err = rb.readSource(rb.buffer[rb.tail : rb.tail+lCapacity])
 		if n != 0 {
 			rb.tail = (rb.tail + n) % cap(rb.buffer)
 			totalLen += n
 		}
 	}
 
-	if rb.head == rb.tail {
+	if totalLen != 0 && rb.head == rb.tail {
 		rb.filled = true
 	}
 
-	if err == io.EOF {
+	if err == io.EOF && rb.nextReader() {
+		err = nil
+	}
+	switch {
+	case err == nil:
+	case err != io.EOF && rb.isRetryable(err):
+		rb.pending = fmt.Errorf("%w: %w", ErrRead, err)
+	default:
 		rb.rd = nil
-		rb.rdErr = io.EOF
+		rb.rdErr = err
+		if err != io.EOF {
+			rb.rdErr = fmt.Errorf("%w: %w", ErrRead, err)
+		}
 	}
 	return totalLen
 }
 
+// refillable reports whether the reader may be asked for more data: it is
+// attached and no error is waiting to be reported.
+func (rb *RingBuffer) refillable() bool {
+	return rb.rd != nil && rb.rdErr == nil && rb.pending == nil
+}
+
+// refillLowWater tops the buffer up to the high-water mark once it holds
+// fewer bytes than the low-water mark, so that reads seldom have to wait on
+// the reader.
+func (rb *RingBuffer) refillLowWater() {
+	if rb.lowWater <= 0 || !rb.refillable() || rb.unlockedLen() >= rb.lowWater {
+		return
+	}
+
+	limit := cap(rb.buffer)
+	if rb.highWater > 0 && rb.highWater < limit {
+		limit = rb.highWater
+	}
+	rb.prefillUpTo(limit)
+}
+
+// fill refills from the reader until at least n bytes are buffered, the
+// buffer is full or the reader is done, and returns the buffered length.
+func (rb *RingBuffer) fill(n int) int {
+	rblen := rb.unlockedLen()
+	for rblen < n && rb.refillable() && rb.unlockedCapacity() != 0 {
+		rblen = rb.prefillBuffer()
+	}
+	return rblen
+}
+
 func (rb *RingBuffer) unlockedCapacity() int {
+	return cap(rb.buffer) - rb.unlockedLen() - rb.retained
+}
+
+func (rb *RingBuffer) unlockedLen() int {
 	if rb.filled {
-		return 0
+		return cap(rb.buffer)
 	}
 
 	delta := rb.tail - rb.head
 	if delta < 0 {
-		return -delta
+		return cap(rb.buffer) + delta
 	} else {
-		return cap(rb.buffer) - delta
+		return delta
 	}
 }
 
-func (rb *RingBuffer) unlockedLen() int {
-	return cap(rb.buffer) - rb.unlockedCapacity()
+func (rb *RingBuffer) Len() int {
+	rb.lock()
+	defer rb.unlock()
+	return rb.unlockedLen()
 }
 
-func (rb *RingBuffer) Discard(n int) (int, error) {
+func (rb *RingBuffer) Cap() int {
+	rb.lock()
+	defer rb.unlock()
+	return cap(rb.buffer)
+}
+
+func (rb *RingBuffer) Available() int {
+	rb.lock()
+	defer rb.unlock()
+	return rb.unlockedCapacity()
+}
+
+func (rb *RingBuffer) IsFull() bool {
+	rb.lock()
+	defer rb.unlock()
+	return rb.filled
+}
+
+func (rb *RingBuffer) IsEmpty() bool {
+	rb.lock()
+	defer rb.unlock()
+	return !rb.filled && rb.head == rb.tail
+}
+
+func (rb *RingBuffer) Head() int {
+	rb.lock()
+	defer rb.unlock()
+	return rb.head
+}
+
+func (rb *RingBuffer) Tail() int {
+	rb.lock()
+	defer rb.unlock()
+	return rb.tail
+}
+
+func (rb *RingBuffer) Filled() bool {
+	rb.lock()
+	defer rb.unlock()
+	return rb.filled
+}
+
+// String describes the buffer geometry, along with a hex dump of the
+// buffered bytes for buffers smaller than 64 bytes.
+func (rb *RingBuffer) String() string {
+	rb.lock()
+	defer rb.unlock()
+
+	str := fmt.Sprintf("RingBuffer{cap=%d len=%d head=%d tail=%d filled=%t",
+		cap(rb.buffer), rb.unlockedLen(), rb.head, rb.tail, rb.filled)
+	if cap(rb.buffer) < 64 {
+		data := make([]byte, rb.unlockedLen())
+		rb.copyToBuffer(data, rb.head)
+		str += fmt.Sprintf(" data=%x", data)
+	}
+	return str + "}"
+}
+
+func (rb *RingBuffer) unlockedDiscard(n int) (int, error) {
+	if n < 0 {
+		return 0, ErrNegativeCount
+	}
 	if n > rb.unlockedLen() {
 		n = rb.unlockedLen()
 	}
+	if n == 0 {
+		return 0, nil
+	}
+	if rb.hash != nil || rb.zeroOnDiscard {
+		first, second := rb.slices(rb.head, n)
+		if rb.hash != nil {
+			rb.hash.write(first)
+			rb.hash.write(second)
+		}
+		if rb.zeroOnDiscard {
+			zero(first)
+			zero(second)
+		}
+	}
 	rb.head = (int(rb.head) + n) % cap(rb.buffer)
 	rb.filled = false
+	rb.lastByte = false
+	rb.runeSize = 0
+	rb.signalNotFull()
+	rb.pos += int64(n)
+	if rb.history != 0 {
+		rb.retained += n
+		if rb.retained > rb.history {
+			rb.retained = rb.history
+		}
+	}
+	return n, nil
+}
+
+// deliver consumes the next n buffered bytes on behalf of a reading method,
+// feeding them to the checksum and the tee first and counting them as read.
+// The error, if any, comes from the tee.
+func (rb *RingBuffer) deliver(n int) error {
+	var err error
+	if rb.checksum != nil || rb.tee != nil {
+		first, second := rb.slices(rb.head, n)
+		if rb.checksum != nil {
+			rb.checksum.Write(first)
+			rb.checksum.Write(second)
+		}
+		if err = rb.teeWrite(first); err == nil {
+			err = rb.teeWrite(second)
+		}
+	}
+	rb.unlockedDiscard(n)
+	rb.stats.BytesRead += uint64(n)
+	return err
+}
+
+// DiscardLast drops the n most recently buffered bytes, or all of them if
+// fewer are buffered, as if they had never been written. Marks taken
+// before are invalidated, as the dropped bytes may have overwritten theirs.
+func (rb *RingBuffer) DiscardLast(n int) (int, error) {
+	rb.lock()
+	defer rb.unlock()
+
+	if n < 0 {
+		return 0, ErrNegativeCount
+	}
+	if rblen := rb.unlockedLen(); n > rblen {
+		n = rblen
+	}
+	if n == 0 {
+		return 0, nil
+	}
+
+	rb.tail = (rb.tail - n + cap(rb.buffer)) % cap(rb.buffer)
+	if rb.zeroOnDiscard {
+		first, second := rb.slices(rb.tail, n)
+		zero(first)
+		zero(second)
+	}
+	rb.filled = false
+	rb.markBase = rb.pos
+	rb.signalNotFull()
 	return n, nil
 }
 
+// Discard skips the next n buffered bytes, or all of them if fewer are
+// buffered, without refilling. It only moves the read position, in
+// constant time, unless WithRollingHash has to roll the discarded bytes.
+// They stay in the backing array until overwritten, which matters when
+// they are sensitive, unless WithZeroOnDiscard wipes them.
+func (rb *RingBuffer) Discard(n int) (int, error) {
+	rb.lock()
+	defer rb.unlock()
+	return rb.unlockedDiscard(n)
+}
+
+func (rb *RingBuffer) Drain() int {
+	rb.lock()
+	defer rb.unlock()
+
+	n, _ := rb.unlockedDiscard(rb.unlockedLen())
+	return n
+}
+
+// DiscardAll is the same as Drain, named after Discard.
+func (rb *RingBuffer) DiscardAll() int {
+	return rb.Drain()
+}
+
 func (rb *RingBuffer) copyToBuffer(data []byte, start int) {
 	end := start + len(data)
 	if end <= cap(rb.buffer) {
@@ -131,35 +663,1328 @@ func (rb *RingBuffer) copyToBuffer(data []byte, start int) {
 	}
 }
 
+func (rb *RingBuffer) slices(start int, n int) ([]byte, []byte) {
+	if n <= 0 {
+		return nil, nil
+	}
+	end := start + n
+	if end <= cap(rb.buffer) {
+		return rb.buffer[start:end], nil
+	}
+	return rb.buffer[start:], rb.buffer[:end%cap(rb.buffer)]
+}
+
+// shortErr is the error reported, in the manner of io.ReadFull, when the
+// source ended after only n of the bytes needed.
+func (rb *RingBuffer) shortErr(n int) error {
+	err := rb.drainedErr()
+	if err == io.EOF && n != 0 {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// drainedErr is the error reported once no more data can be obtained.
+func (rb *RingBuffer) drainedErr() error {
+	if err := rb.takeErr(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// takeErr returns the reader error to report, clearing a pending transient
+// one so that the next call retries the reader.
+func (rb *RingBuffer) takeErr() error {
+	if err := rb.pending; err != nil {
+		rb.pending = nil
+		return err
+	}
+	return rb.rdErr
+}
+
+// peekErr reports why a peek returned only n of the size bytes asked for.
+func (rb *RingBuffer) peekErr(n int, size int) error {
+	if n >= size {
+		return nil
+	}
+	if rb.rd == nil && rb.rdErr == nil {
+		return io.EOF
+	}
+	return rb.takeErr()
+}
+
+// servedErr is peekErr for peeks that, like Read, leave an error of the
+// reader other than io.EOF to the first call that returns no bytes.
+func (rb *RingBuffer) servedErr(n int, size int) error {
+	if n != 0 && (rb.pending != nil || errors.Is(rb.rdErr, ErrRead)) {
+		return nil
+	}
+	return rb.peekErr(n, size)
+}
+
+// Peek copies up to len(p) buffered bytes into p without consuming them,
+// refilling from the reader first if needed. If fewer than len(p) bytes
+// are returned and no reader is left to refill from, the error is the
+// reader's terminal error, or io.EOF when there is none. An error other
+// than io.EOF is only returned once no bytes are left, like with Read.
 func (rb *RingBuffer) Peek(p []byte) (int, error) {
+	rb.lock()
+	defer rb.unlock()
+
 	size := len(p)
 	rblen := rb.unlockedLen()
-	if size > rblen && rb.rd != nil {
+	if size > rblen && rb.refillable() {
 		rblen = rb.prefillBuffer()
 	}
-	if rblen < size {
-		size = rblen
-	} else if rblen > size {
+	if rblen > size {
 		rblen = size
 	}
 
 	rb.copyToBuffer(p[:rblen], int(rb.head))
-	return rblen, rb.rdErr
+	rb.lastByte = false
+	return rblen, rb.servedErr(rblen, size)
 }
 
-func (rb *RingBuffer) Read(p []byte) (int, error) {
+// PeekFilled is like Peek but first tops the buffer up until it is full or
+// the reader is done, for the largest possible lookahead.
+func (rb *RingBuffer) PeekFilled(p []byte) (int, error) {
+	rb.lock()
+	defer rb.unlock()
+
 	size := len(p)
+	rblen := rb.fill(cap(rb.buffer))
+	if rblen > size {
+		rblen = size
+	}
+
+	rb.copyToBuffer(p[:rblen], rb.head)
+	rb.lastByte = false
+	return rblen, rb.servedErr(rblen, size)
+}
+
+// PeekSlice returns up to n buffered bytes as one or two slices of the
+// internal buffer. They must not be modified and are only valid until the
+// next call that alters the buffer.
+func (rb *RingBuffer) PeekSlice(n int) ([]byte, []byte, error) {
+	rb.lock()
+	defer rb.unlock()
+
+	size := n
 	rblen := rb.unlockedLen()
-	if size > rblen && rb.rd != nil {
+	if n > rblen && rb.refillable() {
 		rblen = rb.prefillBuffer()
 	}
-	if rblen < size {
-		size = rblen
-	} else if rblen > size {
-		rblen = size
+	if n > rblen {
+		n = rblen
 	}
 
-	rb.copyToBuffer(p[:rblen], int(rb.head))
-	rb.Discard(rblen)
-	return rblen, rb.rdErr
+	first, second := rb.slices(rb.head, n)
+	rb.lastByte = false
+	return first, second, rb.servedErr(n, size)
+}
+
+// Segments returns the buffered bytes as one or two slices of the internal
+// buffer, without refilling. The same restrictions as PeekSlice apply.
+func (rb *RingBuffer) Segments() ([]byte, []byte) {
+	rb.lock()
+	defer rb.unlock()
+	return rb.slices(rb.head, rb.unlockedLen())
+}
+
+// PeekLast returns the n most recently buffered bytes, or all of them if
+// fewer are buffered, as one or two slices of the internal buffer, without
+// refilling. The same restrictions as PeekSlice apply.
+func (rb *RingBuffer) PeekLast(n int) ([]byte, []byte, error) {
+	rb.lock()
+	defer rb.unlock()
+
+	if n < 0 {
+		return nil, nil, ErrNegativeCount
+	}
+	if rblen := rb.unlockedLen(); n > rblen {
+		n = rblen
+	}
+	first, second := rb.slices((rb.tail-n+cap(rb.buffer))%cap(rb.buffer), n)
+	return first, second, nil
+}
+
+// PeekAll returns everything buffered, like Segments. The first slice is
+// never nil, even when empty, and the second one is nil unless the data
+// wraps around.
+func (rb *RingBuffer) PeekAll() ([]byte, []byte) {
+	rb.lock()
+	defer rb.unlock()
+
+	first, second := rb.slices(rb.head, rb.unlockedLen())
+	if first == nil {
+		first = rb.buffer[rb.head:rb.head]
+	}
+	return first, second
+}
+
+// contiguous returns the first n buffered bytes as a single slice: of the
+// internal buffer if they do not wrap around, a copy otherwise.
+func (rb *RingBuffer) contiguous(n int) []byte {
+	first, second := rb.slices(rb.head, n)
+	if len(second) == 0 {
+		return first
+	}
+	return append(append(make([]byte, 0, n), first...), second...)
+}
+
+// PeekGrow returns the next n bytes without consuming them, enlarging the
+// buffer first if it cannot hold that many. Fewer bytes are only returned,
+// along with the reason, if the source ends first.
+func (rb *RingBuffer) PeekGrow(n int) ([]byte, error) {
+	rb.lock()
+	defer rb.unlock()
+
+	if n < 0 {
+		return nil, ErrNegativeCount
+	}
+	if n+rb.history > cap(rb.buffer) {
+		rb.resize(n + rb.history)
+	}
+
+	rblen := rb.fill(n)
+	if rblen > n {
+		rblen = n
+	}
+	rb.lastByte = false
+	return rb.contiguous(rblen), rb.peekErr(rblen, n)
+}
+
+// PeekFull refills until n bytes are buffered and returns them without
+// consuming them, in the manner of io.ReadFull: the error is io.EOF if
+// the source ended before any byte, io.ErrUnexpectedEOF if it ended
+// before n, and ErrBufferFull if n bytes can never fit.
+func (rb *RingBuffer) PeekFull(n int) ([]byte, error) {
+	rb.lock()
+	defer rb.unlock()
+
+	if n < 0 {
+		return nil, ErrNegativeCount
+	}
+	if n > cap(rb.buffer)-rb.retained {
+		return nil, ErrBufferFull
+	}
+
+	rblen := rb.fill(n)
+	rb.lastByte = false
+	if rblen < n {
+		return nil, rb.shortErr(rblen)
+	}
+	return rb.contiguous(n), nil
+}
+
+// PeekN is like PeekFull but enlarges the buffer first if it cannot hold n
+// bytes, so that only the end of the source can make it fail.
+func (rb *RingBuffer) PeekN(n int) ([]byte, error) {
+	rb.lock()
+	defer rb.unlock()
+
+	if n < 0 {
+		return nil, ErrNegativeCount
+	}
+	if n+rb.history > cap(rb.buffer) {
+		rb.resize(n + rb.history)
+	}
+
+	rblen := rb.fill(n)
+	rb.lastByte = false
+	if rblen < n {
+		return nil, rb.shortErr(rblen)
+	}
+	return rb.contiguous(n), nil
+}
+
+// PeekRange returns n bytes starting off bytes past the read position,
+// without consuming anything, refilling until off+n bytes are buffered.
+// Like for PeekFull, the slice may point into the internal buffer. It
+// fails with the reader's error, or io.EOF, if the source ends first.
+func (rb *RingBuffer) PeekRange(off int, n int) ([]byte, error) {
+	rb.lock()
+	defer rb.unlock()
+
+	if off < 0 || n < 0 {
+		return nil, ErrNegativeCount
+	}
+	if off > cap(rb.buffer)-rb.retained-n {
+		return nil, ErrBufferFull
+	}
+
+	rblen := rb.fill(off + n)
+	rb.lastByte = false
+	if rblen < off+n {
+		return nil, rb.drainedErr()
+	}
+	first, second := rb.slices((rb.head+off)%cap(rb.buffer), n)
+	if len(second) == 0 {
+		return first, nil
+	}
+	return append(append(make([]byte, 0, n), first...), second...), nil
+}
+
+// PeekStrict returns the next n bytes without consuming them, with the
+// semantics of bufio.Reader.Peek: if fewer are returned the error says
+// why, and is ErrBufferFull when n exceeds what the buffer can hold.
+func (rb *RingBuffer) PeekStrict(n int) ([]byte, error) {
+	rb.lock()
+	defer rb.unlock()
+
+	if n < 0 {
+		return nil, ErrNegativeCount
+	}
+	size := cap(rb.buffer) - rb.retained
+
+	var err error
+	if n > size {
+		n, err = size, ErrBufferFull
+	}
+	rblen := rb.fill(n)
+	if rblen > n {
+		rblen = n
+	}
+	rb.lastByte = false
+	if err == nil {
+		err = rb.peekErr(rblen, n)
+	}
+	return rb.contiguous(rblen), err
+}
+
+func (rb *RingBuffer) Bytes() []byte {
+	rb.lock()
+	defer rb.unlock()
+
+	data := make([]byte, rb.unlockedLen())
+	rb.copyToBuffer(data, rb.head)
+	return data
+}
+
+// CompactTo is like Bytes but copies into dst, oldest byte first, and
+// returns how many of the buffered bytes fit.
+func (rb *RingBuffer) CompactTo(dst []byte) int {
+	rb.lock()
+	defer rb.unlock()
+
+	n := rb.unlockedLen()
+	if n > len(dst) {
+		n = len(dst)
+	}
+	rb.copyToBuffer(dst[:n], rb.head)
+	return n
+}
+
+// ReadAt reads len(p) bytes starting off bytes past the current read
+// position. Only the buffered window can be served: offsets that fall
+// outside of it, before or after, fail with ErrOutOfWindow.
+func (rb *RingBuffer) ReadAt(p []byte, off int64) (int, error) {
+	rb.lock()
+	defer rb.unlock()
+
+	if off < 0 || off >= int64(cap(rb.buffer)) {
+		return 0, ErrOutOfWindow
+	}
+
+	n := 0
+	if rblen := rb.fill(int(off) + len(p)); int(off) < rblen {
+		n = rblen - int(off)
+		if n > len(p) {
+			n = len(p)
+		}
+		rb.copyToBuffer(p[:n], (rb.head+int(off))%cap(rb.buffer))
+	}
+	rb.lastByte = false
+
+	if n == len(p) {
+		return n, nil
+	}
+	if rb.rd == nil && rb.rdErr == io.EOF {
+		return n, io.EOF
+	}
+	return n, ErrOutOfWindow
+}
+
+// Read moves up to len(p) bytes into p, refilling from the reader if fewer
+// are buffered. An error from the reader is only returned by the first
+// Read that finds nothing left to serve, never together with data.
+func (rb *RingBuffer) Read(p []byte) (int, error) {
+	rb.lock()
+	defer rb.unlock()
+
+	if rb.deadlinePassed() {
+		return 0, os.ErrDeadlineExceeded
+	}
+	return rb.unlockedRead(p)
+}
+
+func (rb *RingBuffer) unlockedRead(p []byte) (int, error) {
+	if n, ok := rb.readSmall(p); ok {
+		return n, nil
+	}
+	rb.refillLowWater()
+	if len(p) > rb.unlockedLen() && rb.refillable() {
+		rb.prefillBuffer()
+	}
+
+	n, err := rb.consume(p)
+	if err != nil || n != 0 {
+		return n, err
+	}
+	return n, rb.takeErr()
+}
+
+// readSmall serves reads of a few buffered bytes that do not wrap around,
+// when no option needs to see the consumed bytes, bypassing the general
+// copy and discard helpers that dominate their cost.
+func (rb *RingBuffer) readSmall(p []byte) (int, bool) {
+	n := len(p)
+	if n == 0 || n > 16 {
+		return 0, false
+	}
+	if rb.hash != nil || rb.tee != nil || rb.checksum != nil || rb.zeroOnDiscard || rb.history != 0 || rb.lowWater != 0 {
+		return 0, false
+	}
+
+	limit := rb.tail
+	if rb.head >= rb.tail {
+		if rb.head == rb.tail && !rb.filled {
+			return 0, false
+		}
+		limit = cap(rb.buffer)
+	}
+	end := rb.head + n
+	if end > limit {
+		return 0, false
+	}
+
+	if n == 1 {
+		p[0] = rb.buffer[rb.head]
+	} else {
+		copy(p, rb.buffer[rb.head:end])
+	}
+	if end == cap(rb.buffer) {
+		end = 0
+	}
+	rb.head = end
+	rb.filled = false
+	rb.signalNotFull()
+	rb.pos += int64(n)
+	rb.lastByte = true
+	rb.runeSize = 0
+	rb.stats.Reads++
+	rb.stats.BytesRead += uint64(n)
+	return n, true
+}
+
+// consume moves up to len(p) buffered bytes into p, without refilling.
+// The error, if any, comes from the writer set with WithTee.
+func (rb *RingBuffer) consume(p []byte) (int, error) {
+	n := len(p)
+	if rblen := rb.unlockedLen(); n > rblen {
+		n = rblen
+	}
+
+	rb.copyToBuffer(p[:n], rb.head)
+	err := rb.deliver(n)
+	rb.lastByte = n != 0
+	rb.stats.Reads++
+	return n, err
+}
+
+// ReadFunc is a Read without copy: it refills like Read, then hands up to n
+// buffered bytes to fn as one or two slices of the internal buffer, which
+// must not be retained, and consumes them unless fn fails.
+func (rb *RingBuffer) ReadFunc(n int, fn func(a, b []byte) error) (int, error) {
+	rb.lock()
+	defer rb.unlock()
+
+	if n < 0 {
+		return 0, ErrNegativeCount
+	}
+	rb.refillLowWater()
+	if n > rb.unlockedLen() && rb.refillable() {
+		rb.prefillBuffer()
+	}
+	if rblen := rb.unlockedLen(); n > rblen {
+		n = rblen
+	}
+	if n == 0 {
+		return 0, rb.takeErr()
+	}
+
+	first, second := rb.slices(rb.head, n)
+	if err := fn(first, second); err != nil {
+		return 0, err
+	}
+	err := rb.deliver(n)
+	rb.lastByte = true
+	rb.stats.Reads++
+	return n, err
+}
+
+func (rb *RingBuffer) TryRead(p []byte) (int, error) {
+	rb.lock()
+	defer rb.unlock()
+	return rb.consume(p)
+}
+
+// teeWrite hands bytes being delivered to the tee.
+func (rb *RingBuffer) teeWrite(p []byte) error {
+	if rb.tee == nil || len(p) == 0 {
+		return nil
+	}
+	_, err := rb.tee.Write(p)
+	return err
+}
+
+// Tee forwards all bytes subsequently delivered by the reading methods,
+// delimiters included, and WriteTo to w, like io.TeeReader. A nil w stops
+// forwarding.
+func (rb *RingBuffer) Tee(w io.Writer) {
+	rb.lock()
+	defer rb.unlock()
+	rb.tee = w
+}
+
+// ReadFull reads exactly len(p) bytes like io.ReadFull, refilling from the
+// reader as many times as needed.
+func (rb *RingBuffer) ReadFull(p []byte) (int, error) {
+	rb.lock()
+	defer rb.unlock()
+
+	n := 0
+	for n < len(p) {
+		m, err := rb.unlockedRead(p[n:])
+		n += m
+		if m == 0 && !rb.refillable() {
+			break
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+	if n == len(p) {
+		return n, nil
+	}
+
+	return n, rb.shortErr(n)
+}
+
+// ReadV scatters the next bytes across bufs, filling each in turn and
+// refilling from the reader as needed, and returns the total placed. It
+// stops early, with the reason, if the source ends first.
+func (rb *RingBuffer) ReadV(bufs ...[]byte) (int, error) {
+	rb.lock()
+	defer rb.unlock()
+
+	total := 0
+	for _, p := range bufs {
+		n := 0
+		for n < len(p) {
+			m, err := rb.unlockedRead(p[n:])
+			n += m
+			if m == 0 && !rb.refillable() {
+				return total + n, rb.drainedErr()
+			}
+			if err != nil {
+				return total + n, err
+			}
+		}
+		total += n
+	}
+	return total, nil
+}
+
+func (rb *RingBuffer) ReadByte() (byte, error) {
+	rb.lock()
+	defer rb.unlock()
+
+	rb.refillLowWater()
+	if rb.unlockedLen() == 0 && (!rb.refillable() || rb.prefillBuffer() == 0) {
+		return 0, rb.drainedErr()
+	}
+
+	c := rb.buffer[rb.head]
+	if rb.hash != nil {
+		rb.hash.roll(c)
+	}
+	if rb.checksum != nil {
+		rb.checksum.Write(rb.buffer[rb.head : rb.head+1])
+	}
+	err := rb.teeWrite(rb.buffer[rb.head : rb.head+1])
+	if rb.zeroOnDiscard {
+		rb.buffer[rb.head] = 0
+	}
+	rb.head = (rb.head + 1) % cap(rb.buffer)
+	rb.filled = false
+	rb.lastByte = true
+	rb.runeSize = 0
+	rb.signalNotFull()
+	rb.pos++
+	if rb.retained < rb.history {
+		rb.retained++
+	}
+	rb.stats.Reads++
+	rb.stats.BytesRead++
+	return c, err
+}
+
+func (rb *RingBuffer) UnreadByte() error {
+	rb.lock()
+	defer rb.unlock()
+
+	if !rb.lastByte || rb.zeroOnDiscard {
+		return ErrInvalidUnreadByte
+	}
+	if rb.hash != nil {
+		rb.hash.unroll()
+	}
+	rb.head = (rb.head + cap(rb.buffer) - 1) % cap(rb.buffer)
+	rb.filled = rb.head == rb.tail
+	rb.lastByte = false
+	rb.runeSize = 0
+	rb.pos--
+	if rb.retained > 0 {
+		rb.retained--
+	}
+	return nil
+}
+
+func (rb *RingBuffer) ReadRune() (rune, int, error) {
+	rb.lock()
+	defer rb.unlock()
+
+	rblen := rb.unlockedLen()
+	if rblen < utf8.UTFMax {
+		rblen = rb.fill(utf8.UTFMax)
+	}
+	if rblen == 0 {
+		return 0, 0, rb.drainedErr()
+	}
+
+	r, size := rune(rb.buffer[rb.head]), 1
+	if r >= utf8.RuneSelf {
+		var buf [utf8.UTFMax]byte
+		n := rblen
+		if n > len(buf) {
+			n = len(buf)
+		}
+		rb.copyToBuffer(buf[:n], rb.head)
+		r, size = utf8.DecodeRune(buf[:n])
+	}
+	err := rb.deliver(size)
+	rb.lastByte = true
+	rb.stats.Reads++
+	rb.runeSize = size
+	return r, size, err
+}
+
+// UnreadRune unreads the last rune. It is only valid right after ReadRune,
+// and for a single byte rune when a rolling hash is maintained.
+func (rb *RingBuffer) UnreadRune() error {
+	rb.lock()
+	defer rb.unlock()
+
+	size := rb.runeSize
+	if !rb.lastByte || size == 0 || rb.zeroOnDiscard {
+		return ErrInvalidUnreadRune
+	}
+	if rb.hash != nil {
+		if size != 1 {
+			return ErrInvalidUnreadRune
+		}
+		rb.hash.unroll()
+	}
+	rb.head = (rb.head + cap(rb.buffer) - size) % cap(rb.buffer)
+	rb.filled = rb.head == rb.tail
+	rb.lastByte = false
+	rb.runeSize = 0
+	rb.pos -= int64(size)
+	rb.retained -= size
+	if rb.retained < 0 {
+		rb.retained = 0
+	}
+	return nil
+}
+
+// The write path is generic over []byte and string so that WriteString can
+// copy from its argument without converting it first.
+
+func copyIntoBuffer[T []byte | string](rb *RingBuffer, data T, start int) {
+	end := start + len(data)
+	if end <= cap(rb.buffer) {
+		copy(rb.buffer[start:end], data)
+	} else {
+		pivot := cap(rb.buffer) - start
+		copy(rb.buffer[start:], data[:pivot])
+		copy(rb.buffer[:end%cap(rb.buffer)], data[pivot:])
+	}
+}
+
+func makeRoom[T []byte | string](rb *RingBuffer, p T) (T, int) {
+	dropped := 0
+	if len(p) > cap(rb.buffer) {
+		dropped = len(p) - cap(rb.buffer)
+		p = p[dropped:]
+	}
+	if capacity := rb.unlockedCapacity() + rb.retained; len(p) > capacity {
+		rb.unlockedDiscard(len(p) - capacity)
+	}
+	// overwritten bytes take precedence over the history
+	rb.retained = 0
+	return p, dropped
+}
+
+func write[T []byte | string](rb *RingBuffer, p T) (int, error) {
+	rb.stats.Writes++
+	if rb.autoFlush && rb.wr != nil {
+		return writeFlushing(rb, p)
+	}
+	return store(rb, p)
+}
+
+func store[T []byte | string](rb *RingBuffer, p T) (int, error) {
+	var err error
+
+	dropped := 0
+	if rb.overwrite {
+		p, dropped = makeRoom(rb, p)
+	}
+
+	size := len(p)
+	if capacity := rb.unlockedCapacity(); size > capacity {
+		size = capacity
+		err = io.ErrShortWrite
+	}
+	if size == 0 {
+		return 0, err
+	}
+
+	copyIntoBuffer(rb, p[:size], rb.tail)
+	rb.tail = (rb.tail + size) % cap(rb.buffer)
+	if rb.head == rb.tail {
+		rb.filled = true
+	}
+	rb.lastByte = false
+	rb.signalNotEmpty()
+	rb.stats.BytesWritten += uint64(size)
+	return dropped + size, err
+}
+
+func (rb *RingBuffer) Write(p []byte) (int, error) {
+	rb.lock()
+	defer rb.unlock()
+	return write(rb, p)
+}
+
+func (rb *RingBuffer) WriteString(s string) (int, error) {
+	rb.lock()
+	defer rb.unlock()
+	return write(rb, s)
+}
+
+// WriteByte appends c, failing with io.ErrShortWrite if the buffer is full
+// unless it was created with WithOverwrite.
+func (rb *RingBuffer) WriteByte(c byte) error {
+	rb.lock()
+	defer rb.unlock()
+
+	if rb.unlockedCapacity() == 0 {
+		p := [1]byte{c}
+		_, err := write(rb, p[:])
+		return err
+	}
+
+	rb.buffer[rb.tail] = c
+	rb.tail = (rb.tail + 1) % cap(rb.buffer)
+	if rb.head == rb.tail {
+		rb.filled = true
+	}
+	rb.lastByte = false
+	rb.signalNotEmpty()
+	rb.stats.Writes++
+	rb.stats.BytesWritten++
+	return nil
+}
+
+// AvailableSegments returns the free space as one or two slices of the
+// internal buffer, for callers that fill it themselves and then report
+// how much they wrote with Commit. Bytes retained for Seek are excluded.
+func (rb *RingBuffer) AvailableSegments() ([]byte, []byte) {
+	rb.lock()
+	defer rb.unlock()
+	return rb.slices(rb.tail, rb.unlockedCapacity())
+}
+
+// Commit appends the next n bytes of the free space, written through the
+// slices returned by AvailableSegments, to the buffered data.
+func (rb *RingBuffer) Commit(n int) error {
+	rb.lock()
+	defer rb.unlock()
+
+	if n < 0 {
+		return ErrNegativeCount
+	}
+	if n > rb.unlockedCapacity() {
+		return ErrBufferFull
+	}
+	if n == 0 {
+		return nil
+	}
+
+	rb.tail = (rb.tail + n) % cap(rb.buffer)
+	if rb.head == rb.tail {
+		rb.filled = true
+	}
+	rb.lastByte = false
+	rb.signalNotEmpty()
+	rb.stats.Writes++
+	rb.stats.BytesWritten += uint64(n)
+	return nil
+}
+
+func (rb *RingBuffer) writeBuffered(w io.Writer, n int) (int, error) {
+	if rblen := rb.unlockedLen(); n > rblen {
+		n = rblen
+	}
+
+	written := 0
+	for written < n {
+		end := rb.head + n - written
+		if end > cap(rb.buffer) {
+			end = cap(rb.buffer)
+		}
+		size := end - rb.head
+
+		nw, err := w.Write(rb.buffer[rb.head:end])
+		if teeErr := rb.deliver(nw); err == nil {
+			err = teeErr
+		}
+		written += nw
+		if err != nil {
+			return written, err
+		}
+		if nw != size {
+			return written, io.ErrShortWrite
+		}
+	}
+	return written, nil
+}
+
+func (rb *RingBuffer) WriteTo(w io.Writer) (int64, error) {
+	rb.lock()
+	defer rb.unlock()
+
+	rb.stats.Reads++
+	var total int64
+	for {
+		n, err := rb.writeBuffered(w, rb.unlockedLen())
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+		if !rb.refillable() {
+			break
+		}
+		rb.prefillBuffer()
+	}
+
+	if err := rb.takeErr(); err != io.EOF {
+		return total, err
+	}
+	return total, nil
+}
+
+// ReadTo is like Read but moves up to n bytes into w, straight from the
+// internal buffer. Unlike CopyN, it refills at most once, so that it can
+// be used to share the input fairly between several destinations.
+func (rb *RingBuffer) ReadTo(w io.Writer, n int) (int, error) {
+	rb.lock()
+	defer rb.unlock()
+
+	if n < 0 {
+		return 0, ErrNegativeCount
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	rb.refillLowWater()
+	if n > rb.unlockedLen() && rb.refillable() {
+		rb.prefillBuffer()
+	}
+
+	rb.stats.Reads++
+	nw, err := rb.writeBuffered(w, n)
+	if err != nil || nw != 0 {
+		return nw, err
+	}
+	return 0, rb.takeErr()
+}
+
+func (rb *RingBuffer) CopyN(dst io.Writer, n int64) (int64, error) {
+	rb.lock()
+	defer rb.unlock()
+
+	rb.stats.Reads++
+	var written int64
+	for written < n {
+		rblen := rb.unlockedLen()
+		if rblen == 0 {
+			if !rb.refillable() {
+				return written, rb.drainedErr()
+			}
+			rb.prefillBuffer()
+			continue
+		}
+
+		if remaining := n - written; remaining < int64(rblen) {
+			rblen = int(remaining)
+		}
+		nw, err := rb.writeBuffered(dst, rblen)
+		written += int64(nw)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+func (rb *RingBuffer) ReadFrom(r io.Reader) (int64, error) {
+	rb.lock()
+	defer rb.unlock()
+
+	rb.lastByte = false
+
+	var total int64
+	for empty := 0; ; {
+		capacity := rb.unlockedCapacity()
+		if capacity == 0 {
+			break
+		}
+		end := cap(rb.buffer)
+		if rb.tail < rb.head {
+			end = rb.head
+		}
+		if end-rb.tail > capacity {
+			end = rb.tail + capacity
+		}
+
+		n, err := r.Read(rb.buffer[rb.tail:end])
+		if n != 0 {
+			rb.tail = (rb.tail + n) % cap(rb.buffer)
+			rb.filled = rb.head == rb.tail
+			total += int64(n)
+			rb.signalNotEmpty()
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return total, err
+		}
+		if n != 0 {
+			empty = 0
+		} else if empty++; empty >= rb.emptyReadLimit() {
+			return total, io.ErrNoProgress
+		}
+	}
+	return total, nil
+}
+
+func (rb *RingBuffer) resize(size int) {
+	rblen := rb.unlockedLen()
+	if rblen > size {
+		rblen = size
+	}
+
+	buffer := make([]byte, size)
+	rb.copyToBuffer(buffer[:rblen], rb.head)
+
+	rb.buffer = buffer
+	rb.head = 0
+	rb.tail = rblen % size
+	rb.filled = rblen == size
+	rb.lastByte = false
+	rb.retained = 0
+	rb.markBase = rb.pos
+	rb.signalNotFull()
+}
+
+// Compact rotates the backing array so that the buffered bytes start at its
+// beginning, after the bytes retained for Seek, and can be returned as a
+// single slice by Segments until more are written.
+func (rb *RingBuffer) Compact() {
+	rb.lock()
+	defer rb.unlock()
+
+	k := (rb.head - rb.retained + cap(rb.buffer)) % cap(rb.buffer)
+	if k == 0 {
+		return
+	}
+
+	buffer := rb.buffer[:cap(rb.buffer)]
+	rb.scratch = append(rb.scratch[:0], buffer[:k]...)
+	copy(buffer, buffer[k:])
+	copy(buffer[len(buffer)-k:], rb.scratch)
+	rb.head = rb.retained
+	rb.tail = (rb.tail - k + cap(rb.buffer)) % cap(rb.buffer)
+}
+
+// ResetSize replaces the backing array by one of the given size, keeping
+// as many of the oldest buffered bytes as fit.
+func (rb *RingBuffer) ResetSize(size int) {
+	rb.lock()
+	defer rb.unlock()
+
+	if size <= 0 {
+		return
+	}
+	rb.resize(size + rb.history)
+}
+
+func (rb *RingBuffer) Grow(n int) {
+	rb.lock()
+	defer rb.unlock()
+
+	if n <= 0 {
+		return
+	}
+	rb.resize(cap(rb.buffer) + n)
+}
+
+func (rb *RingBuffer) GrowTo(size int) {
+	rb.lock()
+	defer rb.unlock()
+
+	if size <= cap(rb.buffer) {
+		return
+	}
+	rb.resize(size)
+}
+
+func (rb *RingBuffer) indexByte(c byte, off int) int {
+	first, second := rb.slices(rb.head, rb.unlockedLen())
+	if off < len(first) {
+		if i := bytes.IndexByte(first[off:], c); i >= 0 {
+			return off + i
+		}
+		off = len(first)
+	}
+	if i := bytes.IndexByte(second[off-len(first):], c); i >= 0 {
+		return off + i
+	}
+	return -1
+}
+
+func (rb *RingBuffer) appendBuffered(dst []byte, n int) ([]byte, error) {
+	first, second := rb.slices(rb.head, n)
+	dst = append(dst, first...)
+	dst = append(dst, second...)
+	return dst, rb.deliver(n)
+}
+
+// collect refills the buffer until delim is found or the reader is done.
+// Whenever the buffer fills up without a match its content is copied out
+// and consumed, so that the returned full buffers followed by the first
+// frag buffered bytes form the complete result.
+func (rb *RingBuffer) collect(delim byte) ([][]byte, int, error) {
+	var full [][]byte
+
+	scanned := 0
+	for {
+		if i := rb.indexByte(delim, scanned); i >= 0 {
+			return full, i + 1, nil
+		}
+
+		rblen := rb.unlockedLen()
+		if !rb.refillable() {
+			return full, rblen, rb.drainedErr()
+		}
+		scanned = rblen
+
+		if rb.unlockedCapacity() == 0 {
+			buf, err := rb.appendBuffered(make([]byte, 0, rblen), rblen)
+			full = append(full, buf)
+			if err != nil {
+				return full, 0, err
+			}
+			scanned = 0
+		}
+		rb.prefillBuffer()
+	}
+}
+
+func (rb *RingBuffer) unlockedReadBytes(delim byte) ([]byte, error) {
+	full, frag, err := rb.collect(delim)
+
+	n := frag
+	for _, buf := range full {
+		n += len(buf)
+	}
+
+	line := make([]byte, 0, n)
+	for _, buf := range full {
+		line = append(line, buf...)
+	}
+	line, teeErr := rb.appendBuffered(line, frag)
+	if err == nil {
+		err = teeErr
+	}
+	rb.lastByte = len(line) != 0
+	rb.stats.Reads++
+	return line, err
+}
+
+func (rb *RingBuffer) ReadBytes(delim byte) ([]byte, error) {
+	rb.lock()
+	defer rb.unlock()
+	return rb.unlockedReadBytes(delim)
+}
+
+// ReadRecord is like ReadBytes but leaves the delimiter out of the returned
+// bytes, while still consuming it.
+func (rb *RingBuffer) ReadRecord(delim byte) ([]byte, error) {
+	rb.lock()
+	defer rb.unlock()
+
+	line, err := rb.unlockedReadBytes(delim)
+	if err == nil {
+		line = line[:len(line)-1]
+	}
+	return line, err
+}
+
+func (rb *RingBuffer) ReadString(delim byte) (string, error) {
+	rb.lock()
+	defer rb.unlock()
+
+	full, frag, err := rb.collect(delim)
+
+	n := frag
+	for _, buf := range full {
+		n += len(buf)
+	}
+
+	var sb strings.Builder
+	sb.Grow(n)
+	for _, buf := range full {
+		sb.Write(buf)
+	}
+	first, second := rb.slices(rb.head, frag)
+	sb.Write(first)
+	sb.Write(second)
+	if teeErr := rb.deliver(frag); err == nil {
+		err = teeErr
+	}
+	rb.lastByte = n != 0
+	rb.stats.Reads++
+	return sb.String(), err
+}
+
+// view consumes n bytes and returns them as a single slice: a slice of the
+// internal buffer when they are contiguous, a reused scratch copy when they
+// wrap around. Either way it is only valid until the next call.
+func (rb *RingBuffer) view(n int) ([]byte, error) {
+	first, second := rb.slices(rb.head, n)
+	if len(second) != 0 || rb.zeroOnDiscard {
+		rb.scratch = append(append(rb.scratch[:0], first...), second...)
+		first = rb.scratch
+	}
+	err := rb.deliver(n)
+	rb.lastByte = n != 0
+	rb.stats.Reads++
+	return first, err
+}
+
+// ReadLine follows bufio.Reader.ReadLine: the returned line excludes the
+// trailing "\n" or "\r\n" and is only valid until the next call. Lines that
+// do not fit in the buffer are returned in pieces with isPrefix set.
+func (rb *RingBuffer) ReadLine() ([]byte, bool, error) {
+	rb.lock()
+	defer rb.unlock()
+
+	scanned := 0
+	for {
+		if i := rb.indexByte('\n', scanned); i >= 0 {
+			line, err := rb.view(i + 1)
+			line = line[:len(line)-1]
+			if len(line) != 0 && line[len(line)-1] == '\r' {
+				line = line[:len(line)-1]
+			}
+			return line, false, err
+		}
+		if !rb.refillable() || rb.unlockedCapacity() == 0 {
+			break
+		}
+		scanned = rb.unlockedLen()
+		rb.prefillBuffer()
+	}
+
+	n := rb.unlockedLen()
+	if n == 0 {
+		return nil, false, rb.drainedErr()
+	}
+
+	isPrefix := rb.unlockedCapacity() == 0
+	if isPrefix && n > 1 && rb.buffer[(rb.head+n-1)%cap(rb.buffer)] == '\r' {
+		// keep the '\r' so that a "\r\n" split across calls is still found
+		n--
+	}
+	line, err := rb.view(n)
+	return line, isPrefix, err
+}
+
+func (rb *RingBuffer) DiscardUntil(delim byte) (int, error) {
+	rb.lock()
+	defer rb.unlock()
+
+	discarded := 0
+	for {
+		if i := rb.indexByte(delim, 0); i >= 0 {
+			n, _ := rb.unlockedDiscard(i + 1)
+			return discarded + n, nil
+		}
+
+		n, _ := rb.unlockedDiscard(rb.unlockedLen())
+		discarded += n
+		if !rb.refillable() {
+			return discarded, rb.drainedErr()
+		}
+		rb.prefillBuffer()
+	}
+}
+
+func (rb *RingBuffer) PeekByte(offset int) (byte, error) {
+	rb.lock()
+	defer rb.unlock()
+
+	if offset < 0 {
+		return 0, ErrNegativeCount
+	}
+	if rb.fill(offset+1) <= offset {
+		if !rb.refillable() {
+			return 0, rb.drainedErr()
+		}
+		return 0, io.ErrShortBuffer
+	}
+	rb.lastByte = false
+	return rb.buffer[(rb.head+offset)%cap(rb.buffer)], nil
+}
+
+// ConsumeByte reads the next byte if it is c, refilling first if nothing is
+// buffered, and reports whether it did. Any other byte is left in place.
+func (rb *RingBuffer) ConsumeByte(c byte) (bool, error) {
+	rb.lock()
+	defer rb.unlock()
+
+	if rb.fill(1) == 0 {
+		return false, rb.drainedErr()
+	}
+	if rb.buffer[rb.head] != c {
+		return false, nil
+	}
+	var p [1]byte
+	_, err := rb.consume(p[:])
+	return true, err
+}
+
+// PeekUntil is like PeekSlice but returns everything up to and including
+// the first delim, refilling until it is found, the buffer is full or the
+// reader is done. found reports whether delim is part of the slices.
+func (rb *RingBuffer) PeekUntil(delim byte) ([]byte, []byte, bool, error) {
+	rb.lock()
+	defer rb.unlock()
+
+	rb.lastByte = false
+
+	scanned := 0
+	for {
+		if i := rb.indexByte(delim, scanned); i >= 0 {
+			first, second := rb.slices(rb.head, i+1)
+			return first, second, true, nil
+		}
+		if !rb.refillable() || rb.unlockedCapacity() == 0 {
+			break
+		}
+		scanned = rb.unlockedLen()
+		rb.prefillBuffer()
+	}
+
+	first, second := rb.slices(rb.head, rb.unlockedLen())
+	if !rb.refillable() {
+		return first, second, false, rb.drainedErr()
+	}
+	return first, second, false, nil
+}
+
+// index returns the offset of the first occurrence of sep in the buffered
+// data that starts at or after off, or -1.
+func (rb *RingBuffer) index(sep []byte, off int) int {
+	first, second := rb.slices(rb.head, rb.unlockedLen())
+
+	if off < len(first) {
+		if i := bytes.Index(first[off:], sep); i >= 0 {
+			return off + i
+		}
+	}
+
+	// matches straddling the end of the backing array
+	if len(second) != 0 && len(sep) > 1 {
+		start := len(first) - (len(sep) - 1)
+		if start < off {
+			start = off
+		}
+		if start < len(first) {
+			end := len(sep) - 1
+			if end > len(second) {
+				end = len(second)
+			}
+			window := append(append(make([]byte, 0, 2*len(sep)), first[start:]...), second[:end]...)
+			if i := bytes.Index(window, sep); i >= 0 {
+				return start + i
+			}
+		}
+	}
+
+	if off < len(first) {
+		off = len(first)
+	}
+	if i := bytes.Index(second[off-len(first):], sep); i >= 0 {
+		return off + i
+	}
+	return -1
+}
+
+// Index returns the offset from the read position of the first occurrence
+// of sep, refilling as needed. If sep is not found it returns -1 along with
+// ErrBufferFull if the buffer is full, or the reason the source ended.
+func (rb *RingBuffer) Index(sep []byte) (int, error) {
+	rb.lock()
+	defer rb.unlock()
+
+	rb.lastByte = false
+
+	scanned := 0
+	for {
+		if i := rb.index(sep, scanned); i >= 0 {
+			return i, nil
+		}
+		if !rb.refillable() {
+			return -1, rb.drainedErr()
+		}
+		if rb.unlockedCapacity() == 0 {
+			return -1, ErrBufferFull
+		}
+		if scanned = rb.unlockedLen() - len(sep) + 1; scanned < 0 {
+			scanned = 0
+		}
+		rb.prefillBuffer()
+	}
+}
+
+func zero(p []byte) {
+	for i := range p {
+		p[i] = 0
+	}
 }
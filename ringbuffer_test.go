@@ -3,10 +3,21 @@ package ringbuffer
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
+	"math"
 	"math/rand"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
 const (
@@ -16,6 +27,26 @@ const (
 
 var rb, _ = io.ReadAll(io.LimitReader(rand.New(rand.NewSource(0)), datalen))
 
+// checkInvariants verifies the geometry of the buffer.
+func (rb *RingBuffer) checkInvariants() error {
+	size := cap(rb.buffer)
+	switch {
+	case len(rb.buffer) != size:
+		return fmt.Errorf("len(buffer)=%d differs from cap %d", len(rb.buffer), size)
+	case rb.head < 0 || rb.head >= size:
+		return fmt.Errorf("head=%d out of [0, %d)", rb.head, size)
+	case rb.tail < 0 || rb.tail >= size:
+		return fmt.Errorf("tail=%d out of [0, %d)", rb.tail, size)
+	case rb.filled && rb.head != rb.tail:
+		return fmt.Errorf("filled with head=%d and tail=%d", rb.head, rb.tail)
+	case rb.retained < 0 || rb.retained > rb.history:
+		return fmt.Errorf("retained=%d out of [0, %d]", rb.retained, rb.history)
+	case rb.unlockedLen()+rb.retained > size:
+		return fmt.Errorf("len=%d and retained=%d exceed cap %d", rb.unlockedLen(), rb.retained, size)
+	}
+	return nil
+}
+
 func Test(t *testing.T) {
 	r := bytes.NewReader(rb)
 
@@ -44,6 +75,793 @@ func Test(t *testing.T) {
 	}
 }
 
+func TestReadEOF(t *testing.T) {
+	r := iotest.DataErrReader(bytes.NewReader(rb[:datalen-1]))
+
+	hasher := sha256.New()
+	hasher.Write(rb[:datalen-1])
+	sum1 := hasher.Sum(nil)
+
+	hasher.Reset()
+
+	rbuf := NewReaderSize(r, bufsize)
+	buf := make([]byte, bufsize)
+	for {
+		n, err := rbuf.Read(buf)
+		if n != 0 {
+			if err != nil {
+				t.Fatalf(`ringbuffer returned %d bytes with error: %v`, n, err)
+			}
+			hasher.Write(buf[:n])
+			continue
+		}
+		if err != io.EOF {
+			t.Fatalf(`ringbuffer returned 0 bytes with error: %v`, err)
+		}
+		break
+	}
+	sum2 := hasher.Sum(nil)
+
+	if !bytes.Equal(sum1, sum2) {
+		t.Fatalf(`ringbuffer produces incorrect output`)
+	}
+
+	if n, err := rbuf.Read(buf); n != 0 || err != io.EOF {
+		t.Fatalf(`drained ringbuffer returned (%d, %v)`, n, err)
+	}
+}
+
+func TestInvalidSize(t *testing.T) {
+	for _, size := range []int{0, -1} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf(`New(%d) did not panic`, size)
+				}
+			}()
+			NewReaderSize(bytes.NewReader(rb[:8]), size)
+		}()
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf(`WithRollingHash(%d) did not panic`, size)
+				}
+			}()
+			NewWithRollingHash(bytes.NewReader(rb[:8]), 8, size)
+		}()
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf(`NewReaderAtSize at offset %d did not panic`, size-1)
+				}
+			}()
+			NewReaderAtSize(bytes.NewReader(rb[:8]), int64(size-1), 8)
+		}()
+	}
+}
+
+func TestFullEmpty(t *testing.T) {
+	rbuf := New(8)
+	if !rbuf.IsEmpty() || rbuf.IsFull() {
+		t.Fatalf(`new ringbuffer should be empty`)
+	}
+
+	buf := make([]byte, 8)
+	for i := 0; i < 3; i++ {
+		rbuf.Write(buf[:5])
+		if rbuf.IsEmpty() || rbuf.IsFull() {
+			t.Fatalf(`partially filled ringbuffer reported as empty or full`)
+		}
+
+		// head == tail in both cases below
+		rbuf.Write(buf[:3])
+		if rbuf.IsEmpty() || !rbuf.IsFull() || rbuf.Len() != 8 {
+			t.Fatalf(`filled ringbuffer not reported as full`)
+		}
+		rbuf.Read(buf)
+		if !rbuf.IsEmpty() || rbuf.IsFull() || rbuf.Len() != 0 {
+			t.Fatalf(`drained ringbuffer not reported as empty`)
+		}
+
+		// shift head and tail for the next round
+		rbuf.Write(buf[:3])
+		rbuf.Discard(3)
+	}
+}
+
+func TestDiscard(t *testing.T) {
+	rbuf := New(8)
+	rbuf.Write(rb[:6])
+
+	if n, err := rbuf.Discard(-1); n != 0 || err != ErrNegativeCount {
+		t.Fatalf(`Discard(-1) returned (%d, %v)`, n, err)
+	}
+	if rbuf.Len() != 6 {
+		t.Fatalf(`Discard(-1) changed the buffered length to %d`, rbuf.Len())
+	}
+
+	if n, err := rbuf.Discard(2); n != 2 || err != nil {
+		t.Fatalf(`Discard(2) returned (%d, %v)`, n, err)
+	}
+	if n, err := rbuf.Discard(100); n != 4 || err != nil {
+		t.Fatalf(`Discard(100) returned (%d, %v)`, n, err)
+	}
+	if !rbuf.IsEmpty() {
+		t.Fatalf(`oversized Discard left %d bytes`, rbuf.Len())
+	}
+	rbuf.Write(rb[:8])
+	if n, err := rbuf.Discard(0); n != 0 || err != nil || !rbuf.IsFull() {
+		t.Fatalf(`Discard(0) on a full buffer returned (%d, %v) and left %d bytes`, n, err, rbuf.Len())
+	}
+}
+
+func TestBoundaries(t *testing.T) {
+	const size = 8
+
+	for offset := 0; offset <= size; offset++ {
+		rbuf := New(size)
+		buf := make([]byte, size)
+
+		// move head and tail to offset, then make the next write end
+		// exactly on the end of the backing array
+		rbuf.Write(rb[:offset])
+		rbuf.Read(buf)
+		if n, _ := rbuf.Write(rb[:size-offset%size]); n != size-offset%size {
+			t.Fatalf(`offset %d: short write of %d bytes`, offset, n)
+		}
+		if err := rbuf.checkInvariants(); err != nil {
+			t.Fatalf(`offset %d: %v`, offset, err)
+		}
+
+		want := append([]byte(nil), rb[:size-offset%size]...)
+		want = append(want, rb[size:size+offset%size]...)
+		rbuf.Write(rb[size : size+offset%size])
+		if n, _ := rbuf.Peek(buf); !bytes.Equal(buf[:n], want) {
+			t.Fatalf(`offset %d: peeked %x, want %x`, offset, buf[:n], want)
+		}
+		if n, _ := rbuf.Read(buf); !bytes.Equal(buf[:n], want) {
+			t.Fatalf(`offset %d: read %x, want %x`, offset, buf[:n], want)
+		}
+		if err := rbuf.checkInvariants(); err != nil || !rbuf.IsEmpty() {
+			t.Fatalf(`offset %d: %v with %d bytes left after drain`, offset, err, rbuf.Len())
+		}
+	}
+
+	// reads landing on the end of the buffer while refilling from a reader
+	for chunk := 1; chunk <= size; chunk++ {
+		rbuf := NewReaderSize(bytes.NewReader(rb[:1024]), size)
+		buf := make([]byte, chunk)
+		var out []byte
+		for {
+			n, err := rbuf.Read(buf)
+			out = append(out, buf[:n]...)
+			if err := rbuf.checkInvariants(); err != nil {
+				t.Fatalf(`chunk %d: %v`, chunk, err)
+			}
+			if err == io.EOF {
+				break
+			}
+		}
+		if !bytes.Equal(out, rb[:1024]) {
+			t.Fatalf(`chunk %d: ringbuffer produces incorrect output`, chunk)
+		}
+	}
+}
+
+// dataErrReader returns all of its data along with err in a single Read.
+type dataErrReader struct {
+	data []byte
+	err  error
+}
+
+func (r *dataErrReader) Read(p []byte) (int, error) {
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	if len(r.data) == 0 {
+		return n, r.err
+	}
+	return n, nil
+}
+
+func TestReadDeferredErr(t *testing.T) {
+	r := &dataErrReader{data: rb[:6], err: io.ErrUnexpectedEOF}
+	rbuf := NewReaderSize(r, 8)
+	buf := make([]byte, 4)
+
+	if n, err := rbuf.Peek(buf); n != 4 || err != nil {
+		t.Fatalf(`Peek returned (%d, %v)`, n, err)
+	}
+	if n, err := rbuf.Read(buf); n != 4 || err != nil || !bytes.Equal(buf, rb[:4]) {
+		t.Fatalf(`first Read returned (%d, %v)`, n, err)
+	}
+	if n, err := rbuf.Peek(buf); n != 2 || err != nil {
+		t.Fatalf(`short Peek returned (%d, %v)`, n, err)
+	}
+	queue := New(8)
+	queue.Write(rb[:3])
+	if n, err := queue.Peek(make([]byte, 5)); n != 3 || err != io.EOF {
+		t.Fatalf(`short Peek without a reader returned (%d, %v)`, n, err)
+	}
+	if n, err := rbuf.Read(buf); n != 2 || err != nil || !bytes.Equal(buf[:n], rb[4:6]) {
+		t.Fatalf(`second Read returned (%d, %v)`, n, err)
+	}
+	if n, err := rbuf.Read(buf); n != 0 || !errors.Is(err, io.ErrUnexpectedEOF) || !errors.Is(err, ErrRead) {
+		t.Fatalf(`drained Read returned (%d, %v)`, n, err)
+	}
+}
+
+// emptyReader never returns any data, nor any error.
+type emptyReader struct {
+	reads int
+}
+
+func (r *emptyReader) Read(p []byte) (int, error) {
+	r.reads++
+	return 0, nil
+}
+
+func TestNoProgress(t *testing.T) {
+	r := &emptyReader{}
+	rbuf := New(8, WithReader(r), WithMaxEmptyReads(5))
+	buf := make([]byte, 4)
+
+	if n, err := rbuf.ReadFull(buf); n != 0 || !errors.Is(err, io.ErrNoProgress) {
+		t.Fatalf(`ReadFull returned (%d, %v)`, n, err)
+	}
+	if r.reads != 5 {
+		t.Fatalf(`reader was called %d times, want 5`, r.reads)
+	}
+}
+
+func TestScan(t *testing.T) {
+	// a split function written for bufio.Scanner may assume data is only
+	// empty at EOF
+	firstByte := func(data []byte, atEOF bool) (int, []byte, error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+		if data[0] == '.' {
+			return 1, data[:1], bufio.ErrFinalToken
+		}
+		return 1, data[:1], nil
+	}
+
+	rbuf := NewReaderSize(strings.NewReader("ab.cd"), 8)
+	var tokens []string
+	for {
+		token, err := rbuf.Scan(firstByte)
+		if token != nil {
+			tokens = append(tokens, string(token))
+		}
+		if err != nil {
+			if err != io.EOF {
+				t.Fatal(err)
+			}
+			break
+		}
+	}
+	if strings.Join(tokens, " ") != "a b ." {
+		t.Fatalf(`Scan returned %q`, tokens)
+	}
+	if !rbuf.SourceExhausted() {
+		t.Fatal(`source not exhausted after the final token`)
+	}
+	rbuf.Discard(rbuf.Len())
+	if n, err := rbuf.Read(make([]byte, 4)); n != 0 || err != io.EOF {
+		t.Fatalf(`drained Read returned (%d, %v)`, n, err)
+	}
+}
+
+func TestPeekRangeOverflow(t *testing.T) {
+	rbuf := NewReaderSize(bytes.NewReader(rb[:8]), 8)
+	if p, err := rbuf.PeekRange(math.MaxInt, 1); err != ErrBufferFull {
+		t.Fatalf(`PeekRange(math.MaxInt, 1) returned (%x, %v)`, p, err)
+	}
+}
+
+func TestChanRestart(t *testing.T) {
+	rbuf := NewReaderSize(bytes.NewReader(rb[:64]), 16)
+
+	ch := rbuf.Chan()
+	<-ch
+	rbuf.CloseChan()
+	for range ch {
+	}
+
+	var rest []byte
+	for chunk := range rbuf.Chan() {
+		rest = append(rest, chunk...)
+	}
+	if len(rest) == 0 || !bytes.HasSuffix(rb[:64], rest) {
+		t.Fatalf(`restarted Chan delivered %d bytes`, len(rest))
+	}
+}
+
+func TestBlocking(t *testing.T) {
+	data := rb[:1<<16]
+	rbuf := New(16, WithLocking())
+	ctx := context.Background()
+
+	go func() {
+		for p := data; len(p) != 0; {
+			chunk := p
+			if len(chunk) > 100 {
+				chunk = chunk[:100]
+			}
+			n, err := rbuf.WriteContext(ctx, chunk)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			p = p[n:]
+		}
+	}()
+
+	var out bytes.Buffer
+	buf := make([]byte, 7)
+	for out.Len() < len(data) {
+		n, err := rbuf.ReadContext(ctx, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out.Write(buf[:n])
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatal(`ReadContext received different bytes`)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if n, err := rbuf.ReadContext(canceled, buf); n != 0 || err != context.Canceled {
+		t.Fatalf(`ReadContext on an empty buffer returned (%d, %v)`, n, err)
+	}
+	if p, err := rbuf.PeekContext(canceled, 4); len(p) != 0 || err != context.Canceled {
+		t.Fatalf(`PeekContext on an empty buffer returned (%x, %v)`, p, err)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		rbuf.Write(rb[:4])
+	}()
+	if err := rbuf.WaitForBytes(ctx, 4); err != nil || rbuf.Len() != 4 {
+		t.Fatalf(`WaitForBytes returned %v with %d bytes buffered`, err, rbuf.Len())
+	}
+
+	canceled, cancel = context.WithCancel(ctx)
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if n, err := rbuf.WriteContext(canceled, rb[:20]); n != 12 || err != context.Canceled {
+		t.Fatalf(`WriteContext on a full buffer returned (%d, %v)`, n, err)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	rbuf := New(8)
+	buf := make([]byte, 8)
+
+	rbuf.Write(rb[:6])
+	token := rbuf.Mark()
+	rbuf.Read(buf[:4])
+	// lands after the buffered bytes, clear of those just read
+	rbuf.Write(rb[6:8])
+	if err := rbuf.Restore(token); err != nil {
+		t.Fatalf(`Restore returned %v`, err)
+	}
+	if n, _ := rbuf.Read(buf); !bytes.Equal(buf[:n], rb[:8]) {
+		t.Fatalf(`Read after Restore returned %x`, buf[:n])
+	}
+
+	rbuf.Write(rb[:8])
+	token = rbuf.Mark()
+	rbuf.Read(buf[:4])
+	rbuf.Write(rb[8:10])
+	if err := rbuf.Restore(token); err != ErrMarkInvalidated {
+		t.Fatalf(`Restore over overwritten bytes returned %v`, err)
+	}
+	if n, _ := rbuf.Read(buf); !bytes.Equal(buf[:n], append(rb[4:8:8], rb[8:10]...)) {
+		t.Fatalf(`Read after a failed Restore returned %x`, buf[:n])
+	}
+}
+
+func TestUnmarshalEmpty(t *testing.T) {
+	empty := New(8)
+	if err := empty.UnmarshalBinary([]byte{0, 0, 0, 0}); err != ErrInvalidEncoding {
+		t.Fatalf(`UnmarshalBinary of a zero size returned %v`, err)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	var data bytes.Buffer
+	data.WriteString("h\u00e9llo\nw\u00f6rld\r\nline\n")
+	binary.Write(&data, binary.BigEndian, uint32(3))
+	data.WriteString("abc")
+	data.Write(rb[:100])
+	want := sha256.Sum256(data.Bytes())
+
+	rbuf := New(16, WithReader(&data), WithChecksum(sha256.New()))
+	rbuf.ReadByte()
+	rbuf.ReadRune()
+	rbuf.ReadString('\n')
+	rbuf.ReadLine()
+	rbuf.ReadBytes('\n')
+	rbuf.ReadFrame(binary.BigEndian)
+	rbuf.Scan(bufio.ScanBytes)
+	rbuf.Read(make([]byte, 10))
+	io.Copy(io.Discard, rbuf)
+
+	if got := rbuf.Checksum(); !bytes.Equal(got, want[:]) {
+		t.Fatalf(`Checksum()=%x, want %x`, got, want)
+	}
+}
+
+func TestStats(t *testing.T) {
+	rbuf := NewReaderSize(strings.NewReader("ab\n\u00e9line\nrest"), 16)
+	rbuf.ReadByte()
+	rbuf.ReadBytes('\n')
+	rbuf.ReadRune()
+	rbuf.ReadLine()
+	rbuf.WriteTo(io.Discard)
+
+	if stats := rbuf.Stats(); stats.Reads != 5 || stats.BytesRead != 14 {
+		t.Fatalf(`Stats() reports %d reads of %d bytes, want 5 reads of 14 bytes`, stats.Reads, stats.BytesRead)
+	}
+}
+
+func TestHistoryGrowth(t *testing.T) {
+	var data bytes.Buffer
+	data.Write(rb[:10])
+	binary.Write(&data, binary.BigEndian, uint32(8))
+	data.Write(rb[:8])
+
+	rbuf := NewWithHistory(&data, 8, 8)
+	if _, err := rbuf.ReadFull(make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := rbuf.WaitForBytes(ctx, 12); err != io.ErrShortBuffer {
+		t.Fatalf(`WaitForBytes returned %v`, err)
+	}
+	if payload, err := rbuf.ReadFrame(binary.BigEndian); err != nil || !bytes.Equal(payload, rb[:8]) {
+		t.Fatalf(`ReadFrame returned (%x, %v)`, payload, err)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	// the budget is charged for what the reader returns, not what it was
+	// offered, so 90 one-byte reads fit in the initial burst of 100 bytes
+	rbuf := New(16, WithReader(iotest.OneByteReader(bytes.NewReader(rb[:90]))), WithRateLimit(100))
+	start := time.Now()
+	if _, err := rbuf.ReadFull(make([]byte, 90)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+		t.Fatalf(`reading within the burst took %v`, elapsed)
+	}
+
+	// a throttled ReadBytes must not see a concurrent DiscardAll midway
+	line := strings.Repeat("a", 20) + "\n"
+	rbuf = New(32, WithReader(strings.NewReader(line)), WithLocking(), WithRateLimit(16))
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				rbuf.DiscardAll()
+			}
+		}
+	}()
+	got, err := rbuf.ReadBytes('\n')
+	close(stop)
+	wg.Wait()
+	if err == nil && !strings.HasSuffix(string(got), "\n") {
+		t.Fatalf(`ReadBytes returned %q`, got)
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// timeoutReader returns its chunks in turn, the first one along with a
+// timeout, then io.EOF.
+type timeoutReader struct {
+	chunks []string
+}
+
+func (r *timeoutReader) Read(p []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.chunks[0])
+	r.chunks = r.chunks[1:]
+	if len(r.chunks) == 1 {
+		return n, timeoutError{}
+	}
+	return n, nil
+}
+
+func TestRetryableErr(t *testing.T) {
+	rbuf := NewReaderSize(&timeoutReader{chunks: []string{"abcd", "efgh"}}, 16)
+	buf := make([]byte, 16)
+
+	if n, err := rbuf.Read(buf); n != 4 || err != nil {
+		t.Fatalf(`Read returned (%d, %v)`, n, err)
+	}
+	if rbuf.Len() != 0 || rbuf.SourceExhausted() {
+		t.Fatalf(`Len()=%d SourceExhausted()=%v after first Read`, rbuf.Len(), rbuf.SourceExhausted())
+	}
+	var netErr net.Error
+	if n, err := rbuf.Read(buf); n != 0 || !errors.As(err, &netErr) || !errors.Is(err, ErrRead) {
+		t.Fatalf(`Read after the timeout returned (%d, %v)`, n, err)
+	}
+	if err := rbuf.Err(); err != nil {
+		t.Fatalf(`Err()=%v once the timeout was reported`, err)
+	}
+	if n, err := rbuf.Read(buf); string(buf[:n]) != "efgh" || err != nil {
+		t.Fatalf(`Read after retrying returned (%q, %v)`, buf[:n], err)
+	}
+	if n, err := rbuf.Read(buf); n != 0 || err != io.EOF {
+		t.Fatalf(`drained Read returned (%d, %v)`, n, err)
+	}
+
+	rbuf = NewReaderSize(&timeoutReader{chunks: []string{"abcd", "efgh"}}, 16)
+	rbuf.Read(buf[:4])
+	for i := 0; i < 2; i++ {
+		if err := rbuf.Err(); !errors.As(err, &netErr) {
+			t.Fatalf(`Err()=%v, want the timeout`, err)
+		}
+	}
+	if n, err := rbuf.ReadFull(buf[:8]); n != 0 || !errors.As(err, &netErr) {
+		t.Fatalf(`ReadFull after Err returned (%d, %v)`, n, err)
+	}
+	if n, err := rbuf.ReadFull(buf[:8]); string(buf[:n]) != "efgh" || err != io.ErrUnexpectedEOF {
+		t.Fatalf(`ReadFull returned (%q, %v)`, buf[:n], err)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, io.ErrShortWrite
+}
+
+func TestTeeErr(t *testing.T) {
+	buf := make([]byte, 4)
+
+	rbuf := NewReaderSize(bytes.NewReader(rb[:8]), 8)
+	rbuf.Tee(failingWriter{})
+	if n, err := rbuf.ReadFull(buf); n != 4 || err != io.ErrShortWrite {
+		t.Fatalf(`ReadFull returned (%d, %v)`, n, err)
+	}
+	if n, err := rbuf.ReadV(buf); n != 4 || err != io.ErrShortWrite {
+		t.Fatalf(`ReadV returned (%d, %v)`, n, err)
+	}
+
+	var tee bytes.Buffer
+	rbuf = NewReaderSize(strings.NewReader("line\nrest of the input"), 8)
+	rbuf.Tee(&tee)
+	rbuf.ReadBytes('\n')
+	io.Copy(io.Discard, rbuf)
+	if tee.String() != "line\nrest of the input" {
+		t.Fatalf(`tee received %q`, tee.String())
+	}
+
+	rbuf = NewReaderSize(strings.NewReader("line\n"), 8)
+	rbuf.Tee(failingWriter{})
+	if line, err := rbuf.ReadBytes('\n'); string(line) != "line\n" || err != io.ErrShortWrite {
+		t.Fatalf(`ReadBytes returned (%q, %v)`, line, err)
+	}
+}
+
+func TestSPSC(t *testing.T) {
+	data := rb[:1<<20]
+	s := NewSPSC(1000)
+
+	go func() {
+		for p := data; len(p) != 0; {
+			chunk := p
+			if len(chunk) > 777 {
+				chunk = chunk[:777]
+			}
+			n, _ := s.Write(chunk)
+			p = p[n:]
+			if n == 0 {
+				runtime.Gosched()
+			}
+		}
+	}()
+
+	var out bytes.Buffer
+	buf := make([]byte, 333)
+	for out.Len() < len(data) {
+		n, _ := s.Read(buf)
+		out.Write(buf[:n])
+		if n == 0 {
+			runtime.Gosched()
+		}
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatal(`SPSC delivered different bytes`)
+	}
+}
+
+func TestBroadcaster(t *testing.T) {
+	data := rb[:1<<20]
+	b := NewBroadcaster(bytes.NewReader(data), 4096, 3)
+
+	outs := make([]bytes.Buffer, 3)
+	errs := make([]error, 3)
+	var wg sync.WaitGroup
+	for id := range outs {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			buf := make([]byte, 1000+id*500)
+			for {
+				n, err := b.ReadFor(id, buf)
+				outs[id].Write(buf[:n])
+				if err != nil {
+					if err != io.EOF {
+						errs[id] = err
+					}
+					return
+				}
+			}
+		}(id)
+	}
+	wg.Wait()
+
+	for id := range outs {
+		if errs[id] != nil {
+			t.Fatalf(`consumer %d: %v`, id, errs[id])
+		}
+		if !bytes.Equal(outs[id].Bytes(), data) {
+			t.Fatalf(`consumer %d received different bytes`, id)
+		}
+	}
+}
+
+// TestBufioCompat runs the same calls against bufio.Reader and the shim.
+func TestBufioCompat(t *testing.T) {
+	type reader interface {
+		ReadRune() (rune, int, error)
+		UnreadRune() error
+		UnreadByte() error
+		ReadSlice(byte) ([]byte, error)
+		ReadString(byte) (string, error)
+		ReadLine() ([]byte, bool, error)
+		Peek(int) ([]byte, error)
+		Discard(int) (int, error)
+		Buffered() int
+		Read([]byte) (int, error)
+		ReadByte() (byte, error)
+		Reset(io.Reader)
+	}
+	input := "h\u00e9llo\nw\u00f6rld\r\nlonger than sixteen bytes\ntail"
+	script := func(rd reader) string {
+		var out bytes.Buffer
+		r, size, err := rd.ReadRune()
+		fmt.Fprintln(&out, r, size, err)
+		r, size, err = rd.ReadRune()
+		fmt.Fprintln(&out, r, size, err, rd.UnreadRune(), rd.UnreadRune(), rd.UnreadByte())
+		line, err := rd.ReadSlice('\n')
+		fmt.Fprintf(&out, "%q %v %d\n", line, err, rd.Buffered())
+		p, err := rd.Peek(17)
+		fmt.Fprintf(&out, "%q %v\n", p, err)
+		line, isPrefix, err := rd.ReadLine()
+		fmt.Fprintf(&out, "%q %v %v\n", line, isPrefix, err)
+		line, err = rd.ReadSlice('\n')
+		fmt.Fprintf(&out, "%q %v\n", line, err)
+		n, err := rd.Discard(-1)
+		fmt.Fprintln(&out, n, err)
+		str, err := rd.ReadString('\n')
+		fmt.Fprintf(&out, "%q %v\n", str, err)
+		str, err = rd.ReadString('\n')
+		fmt.Fprintf(&out, "%q %v\n", str, err)
+		return out.String()
+	}
+
+	want := script(bufio.NewReaderSize(bytes.NewReader([]byte(input)), 16))
+	got := script(NewBufioReaderSize(bytes.NewReader([]byte(input)), 16))
+	if got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	for _, rd := range []reader{
+		bufio.NewReaderSize(bytes.NewReader(rb[:100]), 16),
+		NewBufioReaderSize(bytes.NewReader(rb[:100]), 16),
+	} {
+		if n, err := rd.Discard(50); n != 50 || err != nil {
+			t.Fatalf(`%T: Discard(50) returned (%d, %v)`, rd, n, err)
+		}
+		if n, err := rd.Discard(100); n != 50 || err != io.EOF {
+			t.Fatalf(`%T: Discard(100) returned (%d, %v)`, rd, n, err)
+		}
+
+		rd.Reset(strings.NewReader("\u00e9\u00e9"))
+		rd.ReadRune()
+		rd.UnreadByte()
+		rd.ReadByte()
+		if err := rd.UnreadRune(); err != bufio.ErrInvalidUnreadRune {
+			t.Fatalf(`%T: UnreadRune after ReadByte returned %v`, rd, err)
+		}
+		rd.Reset(strings.NewReader("ab"))
+		rd.Read(make([]byte, 2))
+		if err := rd.UnreadRune(); err != bufio.ErrInvalidUnreadRune {
+			t.Fatalf(`%T: UnreadRune after Reset returned %v`, rd, err)
+		}
+	}
+}
+
+// FuzzOperations runs sequences of Write, Read, Peek and Discard, two
+// bytes per operation for its kind and size, against a bytes.Buffer.
+func FuzzOperations(f *testing.F) {
+	f.Add(uint8(8), []byte{0, 5, 1, 3, 0, 6, 2, 8, 3, 2, 1, 8})
+	f.Add(uint8(1), []byte{0, 1, 2, 1, 1, 1, 0, 2, 3, 0})
+	f.Add(uint8(7), []byte{0, 7, 3, 0, 2, 7, 1, 7, 0, 3, 1, 1, 0, 5, 2, 7})
+
+	f.Fuzz(func(t *testing.T, size uint8, ops []byte) {
+		if size == 0 {
+			return
+		}
+		rbuf := New(int(size))
+		var ref bytes.Buffer
+		data := rb
+
+		for i := 0; i+1 < len(ops); i += 2 {
+			n := int(ops[i+1])
+			switch ops[i] % 4 {
+			case 0:
+				m, _ := rbuf.Write(data[:n])
+				want := int(size) - ref.Len()
+				if n < want {
+					want = n
+				}
+				if m != want {
+					t.Fatalf(`op %d: Write(%d) stored %d bytes, want %d`, i/2, n, m, want)
+				}
+				ref.Write(data[:m])
+				data = data[m:]
+			case 1:
+				buf := make([]byte, n)
+				m, _ := rbuf.Read(buf)
+				if want := ref.Next(n); !bytes.Equal(buf[:m], want) {
+					t.Fatalf(`op %d: Read(%d) returned %x, want %x`, i/2, n, buf[:m], want)
+				}
+			case 2:
+				buf := make([]byte, n)
+				m, _ := rbuf.Peek(buf)
+				want := ref.Bytes()
+				if len(want) > n {
+					want = want[:n]
+				}
+				if !bytes.Equal(buf[:m], want) {
+					t.Fatalf(`op %d: Peek(%d) returned %x, want %x`, i/2, n, buf[:m], want)
+				}
+			case 3:
+				m, _ := rbuf.Discard(n)
+				if want := len(ref.Next(n)); m != want {
+					t.Fatalf(`op %d: Discard(%d) skipped %d bytes, want %d`, i/2, n, m, want)
+				}
+			}
+
+			if err := rbuf.checkInvariants(); err != nil {
+				t.Fatalf(`op %d: %v`, i/2, err)
+			}
+			if rbuf.Len() != ref.Len() {
+				t.Fatalf(`op %d: Len()=%d, want %d`, i/2, rbuf.Len(), ref.Len())
+			}
+		}
+	})
+}
+
 func Benchmark_IOReader(b *testing.B) {
 	r := bytes.NewReader(rb)
 	b.SetBytes(int64(r.Len()))
@@ -106,3 +924,89 @@ func Benchmark_PlakarLabs_RingbufferReader(b *testing.B) {
 		r.Reset(rb)
 	}
 }
+
+func Benchmark_PlakarLabs_RingbufferReaderReset(b *testing.B) {
+	r := bytes.NewReader(rb)
+	b.SetBytes(int64(r.Len()))
+	buf := make([]byte, bufsize)
+
+	rd := NewReaderSize(r, bufsize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for {
+			n, err := rd.Read(buf)
+			if err != nil && err != io.EOF {
+				b.Fatalf(`ringbuffer error: %s`, err)
+			}
+			_ = buf[:n]
+			if err == io.EOF {
+				break
+			}
+		}
+		r.Reset(rb)
+		rd.Reset(r)
+	}
+}
+
+func Benchmark_SmallReads(b *testing.B) {
+	data := rb[:1<<20]
+	for _, size := range []int{1, 4, 16} {
+		buf := make([]byte, size)
+		b.Run(fmt.Sprintf("bufio/%d", size), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			r := bytes.NewReader(data)
+			for i := 0; i < b.N; i++ {
+				r.Reset(data)
+				rd := bufio.NewReaderSize(r, 4096)
+				for {
+					if _, err := rd.Read(buf); err == io.EOF {
+						break
+					}
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("ringbuffer/%d", size), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			r := bytes.NewReader(data)
+			for i := 0; i < b.N; i++ {
+				r.Reset(data)
+				rd := NewReaderSize(r, 4096)
+				for {
+					if _, err := rd.Read(buf); err == io.EOF {
+						break
+					}
+				}
+			}
+		})
+	}
+}
+
+func Benchmark_ReadSlice(b *testing.B) {
+	data := bytes.Repeat([]byte("a line of about forty bytes of text...\n"), 1<<14)
+	b.Run("bufio", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		r := bytes.NewReader(data)
+		for i := 0; i < b.N; i++ {
+			r.Reset(data)
+			rd := bufio.NewReaderSize(r, 4096)
+			for {
+				if _, err := rd.ReadSlice('\n'); err == io.EOF {
+					break
+				}
+			}
+		}
+	})
+	b.Run("ringbuffer", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		r := bytes.NewReader(data)
+		for i := 0; i < b.N; i++ {
+			r.Reset(data)
+			rd := NewBufioReaderSize(r, 4096)
+			for {
+				if _, err := rd.ReadSlice('\n'); err == io.EOF {
+					break
+				}
+			}
+		}
+	})
+}
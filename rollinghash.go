@@ -0,0 +1,115 @@
+/*
+ * Copyright (c) 2023 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package ringbuffer
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+var buzhashTable = func() (table [256]uint64) {
+	// splitmix64, so the table is fixed across runs and platforms
+	seed := uint64(0x9e3779b97f4a7c15)
+	for i := range table {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return
+}()
+
+// buzhash is a cyclic polynomial rolling hash over the last len(window)
+// bytes rolled into it.
+type buzhash struct {
+	window []byte
+	pos    int
+	count  int
+	sum    uint64
+
+	// state before the last roll, so that UnreadByte can undo it
+	prevSum   uint64
+	prevOut   byte
+	prevCount int
+}
+
+func newBuzhash(windowSize int) *buzhash {
+	return &buzhash{
+		window: make([]byte, windowSize),
+	}
+}
+
+func (h *buzhash) roll(c byte) {
+	out := h.window[h.pos]
+
+	h.prevSum = h.sum
+	h.prevOut = out
+	h.prevCount = h.count
+
+	h.sum = bits.RotateLeft64(h.sum, 1) ^ buzhashTable[c]
+	if h.count == len(h.window) {
+		h.sum ^= bits.RotateLeft64(buzhashTable[out], len(h.window))
+	} else {
+		h.count++
+	}
+	h.window[h.pos] = c
+	h.pos = (h.pos + 1) % len(h.window)
+}
+
+func (h *buzhash) unroll() {
+	h.pos = (h.pos + len(h.window) - 1) % len(h.window)
+	h.window[h.pos] = h.prevOut
+	h.sum = h.prevSum
+	h.count = h.prevCount
+}
+
+func (h *buzhash) write(p []byte) {
+	for _, c := range p {
+		h.roll(c)
+	}
+}
+
+// WithRollingHash maintains a buzhash fingerprint of the last windowSize
+// bytes consumed through the buffer, so that a content-defined chunker can
+// look for cut points as it reads. It is not rewound by a backward Seek.
+// It panics if windowSize is not positive.
+func WithRollingHash(windowSize int) Option {
+	if windowSize <= 0 {
+		panic(fmt.Sprintf("ringbuffer: invalid rolling hash window %d, must be positive", windowSize))
+	}
+	return func(rb *RingBuffer) {
+		rb.hash = newBuzhash(windowSize)
+	}
+}
+
+func NewWithRollingHash(rd io.Reader, size int, windowSize int) *RingBuffer {
+	return New(size, WithReader(rd), WithRollingHash(windowSize))
+}
+
+// Fingerprint returns the rolling hash of the last consumed bytes, or 0 if
+// the buffer was not created with WithRollingHash.
+func (rb *RingBuffer) Fingerprint() uint64 {
+	rb.lock()
+	defer rb.unlock()
+
+	if rb.hash == nil {
+		return 0
+	}
+	return rb.hash.sum
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright (c) 2023 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package ringbuffer
+
+import (
+	"bufio"
+	"io"
+)
+
+// Scan returns the next token found by split, which is called on the
+// buffered bytes exactly as a bufio.Scanner would, refilling and growing
+// the buffer, up to the WithMaxFrameSize limit, while it asks for more.
+// The token may point into the internal buffer and is only valid until
+// the next call. At the end of the input the error is io.EOF, returned
+// along with the token if split stopped with bufio.ErrFinalToken.
+func (rb *RingBuffer) Scan(split bufio.SplitFunc) ([]byte, error) {
+	rb.lock()
+	defer rb.unlock()
+
+	rb.stats.Reads++
+	for {
+		rblen := rb.unlockedLen()
+		data := rb.contiguous(rblen)
+		atEOF := !rb.refillable() && rb.pending == nil
+		if rblen == 0 && !atEOF {
+			// like bufio.Scanner, only call split on no data at the end
+			if rb.pending != nil {
+				return nil, rb.drainedErr()
+			}
+			rb.prefillBuffer()
+			continue
+		}
+
+		advance, token, err := split(data, atEOF)
+		final := err == bufio.ErrFinalToken
+		switch {
+		case err != nil && !final:
+			return nil, err
+		case advance < 0:
+			return nil, bufio.ErrNegativeAdvance
+		case advance > rblen:
+			return nil, bufio.ErrAdvanceTooFar
+		}
+		if rb.zeroOnDiscard && token != nil {
+			token = append([]byte(nil), token...)
+		}
+		if err := rb.deliver(advance); err != nil {
+			return token, err
+		}
+
+		switch {
+		case final:
+			rb.rd = nil
+			if rb.rdErr == nil {
+				rb.rdErr = io.EOF
+			}
+			return token, rb.drainedErr()
+		case token != nil:
+			return token, nil
+		case advance != 0:
+			continue
+		case atEOF || rb.pending != nil:
+			return nil, rb.drainedErr()
+		}
+
+		if rb.unlockedCapacity() == 0 {
+			if cap(rb.buffer) >= rb.frameLimit() {
+				return nil, bufio.ErrTooLong
+			}
+			size := 2 * cap(rb.buffer)
+			if size > rb.frameLimit() {
+				size = rb.frameLimit()
+			}
+			rb.resize(size)
+		}
+		rb.prefillBuffer()
+	}
+}
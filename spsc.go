@@ -0,0 +1,85 @@
+/*
+ * Copyright (c) 2023 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package ringbuffer
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// SPSC is a lock-free ring buffer for exactly one producer goroutine
+// calling Write and one consumer goroutine calling Read. head and tail
+// are free-running counters, masked into the power-of-two sized buffer.
+type SPSC struct {
+	buffer []byte
+	mask   int64
+
+	head atomic.Int64
+	tail atomic.Int64
+}
+
+func NewSPSC(size int) *SPSC {
+	n := 1
+	for n < size {
+		n <<= 1
+	}
+	return &SPSC{
+		buffer: make([]byte, n),
+		mask:   int64(n - 1),
+	}
+}
+
+func (s *SPSC) Len() int {
+	return int(s.tail.Load() - s.head.Load())
+}
+
+func (s *SPSC) Cap() int {
+	return len(s.buffer)
+}
+
+func (s *SPSC) Write(p []byte) (int, error) {
+	var err error
+
+	tail := s.tail.Load()
+	size := len(p)
+	if capacity := len(s.buffer) - int(tail-s.head.Load()); size > capacity {
+		size = capacity
+		err = io.ErrShortWrite
+	}
+
+	start := int(tail & s.mask)
+	n := copy(s.buffer[start:], p[:size])
+	copy(s.buffer, p[n:size])
+
+	s.tail.Store(tail + int64(size))
+	return size, err
+}
+
+func (s *SPSC) Read(p []byte) (int, error) {
+	head := s.head.Load()
+	size := len(p)
+	if rblen := int(s.tail.Load() - head); size > rblen {
+		size = rblen
+	}
+
+	start := int(head & s.mask)
+	n := copy(p[:size], s.buffer[start:])
+	copy(p[n:size], s.buffer)
+
+	s.head.Store(head + int64(size))
+	return size, nil
+}
@@ -0,0 +1,33 @@
+/*
+ * Copyright (c) 2023 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package ringbuffer
+
+type Stats struct {
+	Reads        uint64 // calls copying bytes out (Read, ReadByte, WriteTo, ...)
+	BytesRead    uint64 // bytes consumed by these calls
+	Writes       uint64 // calls to Write and WriteString
+	BytesWritten uint64 // bytes stored by these calls
+	Refills      uint64 // refills attempted from the reader
+	SourceReads  uint64 // calls to the reader's Read method
+	SourceBytes  uint64 // bytes returned by the reader
+}
+
+func (rb *RingBuffer) Stats() Stats {
+	rb.lock()
+	defer rb.unlock()
+	return rb.stats
+}
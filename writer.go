@@ -0,0 +1,72 @@
+/*
+ * Copyright (c) 2023 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package ringbuffer
+
+import (
+	"errors"
+	"io"
+)
+
+var ErrNoWriter = errors.New("ringbuffer: no writer to flush to")
+
+// WithWriter sets the destination Flush writes the buffered bytes to.
+func WithWriter(w io.Writer) Option {
+	return func(rb *RingBuffer) {
+		rb.wr = w
+	}
+}
+
+// WithAutoFlush makes writes that do not fit flush the oldest buffered
+// bytes to the writer set with WithWriter to make room, like a
+// bufio.Writer, instead of falling short.
+func WithAutoFlush() Option {
+	return func(rb *RingBuffer) {
+		rb.autoFlush = true
+	}
+}
+
+func NewWriterSize(w io.Writer, size int) *RingBuffer {
+	return New(size, WithWriter(w), WithAutoFlush())
+}
+
+// Flush writes all buffered bytes to the writer set with WithWriter.
+func (rb *RingBuffer) Flush() error {
+	rb.lock()
+	defer rb.unlock()
+
+	if rb.wr == nil {
+		return ErrNoWriter
+	}
+	_, err := rb.writeBuffered(rb.wr, rb.unlockedLen())
+	return err
+}
+
+func writeFlushing[T []byte | string](rb *RingBuffer, p T) (int, error) {
+	total := 0
+	for len(p) != 0 {
+		if rb.unlockedCapacity() == 0 {
+			first, _ := rb.slices(rb.head, rb.unlockedLen())
+			if _, err := rb.writeBuffered(rb.wr, len(first)); err != nil {
+				return total, err
+			}
+		}
+		n, _ := store(rb, p)
+		total += n
+		p = p[n:]
+	}
+	return total, nil
+}